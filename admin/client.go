@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+)
+
+// Client is a client of the Handler.
+type Client struct {
+	base string
+	hc   *http.Client
+}
+
+// NewClient returns a Client that talks to the Handler mounted at base, e.g. http://localhost:6060/debug/breakers.
+// If hc is nil, http.DefaultClient is used.
+func NewClient(base string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	return &Client{
+		base: strings.TrimSuffix(base, "/"),
+		hc:   hc,
+	}
+}
+
+// List returns the statistics of all registered breakers.
+func (c *Client) List(ctx context.Context) ([]breaker.Stats, error) {
+	var list []breaker.Stats
+	err := c.do(ctx, http.MethodGet, "/breakers", nil, &list)
+
+	return list, err
+}
+
+// Get returns the statistics of the breaker with name.
+func (c *Client) Get(ctx context.Context, name string) (breaker.Stats, error) {
+	var stats breaker.Stats
+	err := c.do(ctx, http.MethodGet, "/breakers/"+url.PathEscape(name), nil, &stats)
+
+	return stats, err
+}
+
+// Force forces the breaker with name into state.
+func (c *Client) Force(ctx context.Context, name string, state breaker.State) (breaker.Stats, error) {
+	var action string
+	switch state {
+	case breaker.StateForcedOpen:
+		action = actionOpen
+	case breaker.StateForcedClosed:
+		action = actionClose
+	default:
+		action = actionAuto
+	}
+
+	return c.action(ctx, name, action, nil)
+}
+
+// Reset clears the statistics and releases the forced state of the breaker with name.
+func (c *Client) Reset(ctx context.Context, name string) (breaker.Stats, error) {
+	return c.action(ctx, name, actionReset, nil)
+}
+
+// Tune changes the parameters of the breaker with name.
+func (c *Client) Tune(ctx context.Context, name string, params map[string]float64) (breaker.Stats, error) {
+	form := make(url.Values, len(params))
+	for param, value := range params {
+		form.Set(param, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	return c.action(ctx, name, actionTune, form)
+}
+
+func (c *Client) action(ctx context.Context, name, action string, form url.Values) (breaker.Stats, error) {
+	var stats breaker.Stats
+	err := c.do(ctx, http.MethodPost, "/breakers/"+url.PathEscape(name)+"/"+action, form, &stats)
+
+	return stats, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, form url.Values, v interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(requestedWithHeader, "sqlbreaker")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&e); err != nil || len(e.Error) == 0 {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, e.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	register(t, "client a/b")
+	mux := http.NewServeMux()
+	mux.Handle("/debug/breakers/", http.StripPrefix("/debug/breakers", NewHandler()))
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	ctx := context.Background()
+	client := NewClient(svr.URL+"/debug/breakers/", svr.Client())
+
+	list, err := client.List(ctx)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, list)
+
+	stats, err := client.Get(ctx, "client a/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "client a/b", stats.Name)
+
+	for _, state := range []breaker.State{breaker.StateForcedOpen, breaker.StateForcedClosed, breaker.StateAuto} {
+		stats, err = client.Force(ctx, "client a/b", state)
+		assert.NoError(t, err)
+		assert.Equal(t, state, stats.State)
+	}
+
+	stats, err = client.Reset(ctx, "client a/b")
+	assert.NoError(t, err)
+	assert.Equal(t, breaker.StateAuto, stats.State)
+
+	stats, err = client.Tune(ctx, "client a/b", map[string]float64{"k": 3})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), stats.Params["k"])
+
+	_, err = client.Tune(ctx, "client a/b", map[string]float64{"any": 3})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), breaker.ErrUnknownParam.Error())
+
+	_, err = client.Get(ctx, "not-exists")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+
+	_, err = NewClient(svr.URL+"/any", nil).List(ctx)
+	assert.Error(t, err)
+}
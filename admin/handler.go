@@ -0,0 +1,290 @@
+// Package admin provides an http.Handler to inspect and operate the registered breakers.
+//
+// The handler is meant to be mounted next to pprof, e.g.
+//
+//	mux.Handle("/debug/breakers/", http.StripPrefix("/debug/breakers", admin.NewHandler()))
+//
+// It serves the following endpoints relative to the mount point:
+//
+//	GET  /                         lists the breakers as HTML
+//	GET  /health                   reports the health of the breakers as JSON, see HealthChecker
+//	GET  /breakers                 lists the breakers as JSON
+//	GET  /breakers/{name}          shows the breaker as JSON
+//	POST /breakers/{name}/open     forces the breaker open
+//	POST /breakers/{name}/close    forces the breaker closed
+//	POST /breakers/{name}/auto     releases the forced state
+//	POST /breakers/{name}/reset    clears the statistics and releases the forced state
+//	POST /breakers/{name}/tune     changes the parameters given as form values, e.g. k=2&protection=10
+//
+// The POST requests must carry an X-Requested-With header of any value, or an Origin header of the handler's host,
+// as sent by the forms of the HTML page, so that the forms of other sites can't operate the breakers.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+)
+
+const (
+	actionOpen  = "open"
+	actionClose = "close"
+	actionAuto  = "auto"
+	actionReset = "reset"
+	actionTune  = "tune"
+
+	// requestedWithHeader is sent by the clients other than the HTML page, the cross-site forms can't set it.
+	requestedWithHeader = "X-Requested-With"
+)
+
+var (
+	errNotFound        = errors.New("breaker not found")
+	errNotControllable = errors.New("breaker is not controllable")
+	errUnknownAction   = errors.New("unknown action")
+	errCrossSite       = errors.New("cross-site request refused, set the " + requestedWithHeader + " header")
+)
+
+// Handler is an http.Handler that inspects and operates the registered breakers.
+type Handler struct{}
+
+// NewHandler returns a Handler.
+func NewHandler() *Handler {
+	return new(Handler)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch {
+	case len(segments) == 0:
+		if allowMethod(w, r, http.MethodGet) {
+			h.serveIndex(w)
+		}
+	case len(segments) == 1 && segments[0] == "health":
+		NewHealthChecker().ServeHTTP(w, r)
+	case segments[0] != "breakers":
+		writeError(w, http.StatusNotFound, fmt.Errorf("page %q not found", r.URL.Path))
+	case len(segments) == 1:
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, listStats())
+		}
+	case len(segments) == 2:
+		if allowMethod(w, r, http.MethodGet) {
+			h.serveBreaker(w, segments[1])
+		}
+	case len(segments) == 3:
+		if allowMethod(w, r, http.MethodPost) && allowOrigin(w, r) {
+			h.serveAction(w, r, segments[1], segments[2])
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("page %q not found", r.URL.Path))
+	}
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, listStats()); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (h *Handler) serveBreaker(w http.ResponseWriter, name string) {
+	b, ok := breaker.Lookup(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statsOf(b))
+}
+
+func (h *Handler) serveAction(w http.ResponseWriter, r *http.Request, name, action string) {
+	b, ok := breaker.Lookup(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	c, ok := b.(breaker.Controller)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, errNotControllable)
+		return
+	}
+
+	switch action {
+	case actionOpen:
+		c.Force(breaker.StateForcedOpen)
+	case actionClose:
+		c.Force(breaker.StateForcedClosed)
+	case actionAuto:
+		c.Force(breaker.StateAuto)
+	case actionReset:
+		c.Reset()
+	case actionTune:
+		if err := tune(c, r); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusNotFound, errUnknownAction)
+		return
+	}
+
+	// the request is from the html page, go back to it.
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Location", "../../")
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statsOf(b))
+}
+
+func tune(c breaker.Controller, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	for param, values := range r.Form {
+		if len(values) == 0 || len(values[0]) == 0 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return fmt.Errorf("parameter %q: %w", param, err)
+		}
+
+		if err = c.Tune(param, value); err != nil {
+			return fmt.Errorf("parameter %q: %w", param, err)
+		}
+	}
+
+	return nil
+}
+
+func listStats() []breaker.Stats {
+	breakers := breaker.Breakers()
+	list := make([]breaker.Stats, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, statsOf(b))
+	}
+
+	return list
+}
+
+func statsOf(b breaker.Breaker) breaker.Stats {
+	if i, ok := b.(breaker.Inspector); ok {
+		return i.Stats()
+	}
+
+	return breaker.Stats{Name: b.Name()}
+}
+
+func splitPath(path string) ([]string, error) {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if len(segment) == 0 {
+			continue
+		}
+
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+// allowOrigin reports whether the request is sent by a client that sets the X-Requested-With header,
+// or by the HTML page of the handler, otherwise it writes an error, as the request may be a cross-site form.
+func allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if len(r.Header.Get(requestedWithHeader)) > 0 {
+		return true
+	}
+
+	if origin := r.Header.Get("Origin"); len(origin) > 0 {
+		if u, err := url.Parse(origin); err == nil && len(u.Host) > 0 && u.Host == r.Host {
+			return true
+		}
+	}
+
+	writeError(w, http.StatusForbidden, errCrossSite)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.0f%%", f*100)
+	},
+	"actions": func() []string {
+		return []string{actionOpen, actionClose, actionAuto, actionReset}
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>breakers</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<h1>breakers</h1>
+<p><a href="breakers">json</a></p>
+<table>
+<tr><th>name</th><th>state</th><th>accepts</th><th>total</th><th>drop ratio</th><th>p99 latency</th><th>params</th><th>recent reasons</th><th>actions</th></tr>
+{{range .}}{{$action := printf "breakers/%s/" (pathEscape .Name)}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.State}}{{if .DryRun}} (dry run, {{.WouldDrop}} would drop){{end}}{{if .Warmup}} (warming up, {{percent .Warmup}}){{end}}{{if .WaitTimeouts}} ({{.WaitTimeouts}} wait timeouts){{end}}</td>
+<td>{{.Accepts}}</td>
+<td>{{.Total}}</td>
+<td>{{printf "%.4f" .DropRatio}}</td>
+<td>{{.Latency.P99}}</td>
+<td>{{if .Params}}<form method="post" action="{{$action}}tune">{{range $param, $value := .Params}}
+<label>{{$param}} <input name="{{$param}}" value="{{$value}}" size="6"></label>{{end}}
+<button>tune</button></form>{{end}}</td>
+<td>{{range .Reasons}}{{.}}<br>{{end}}</td>
+<td>{{range $name := actions}}<form method="post" action="{{$action}}{{$name}}"><button>{{$name}}</button></form>{{end}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
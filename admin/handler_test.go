@@ -0,0 +1,211 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Index(t *testing.T) {
+	b := register(t, "index a/b")
+	allow, err := b.Allow()
+	assert.NoError(t, err)
+	allow.Reject("boom")
+
+	w := serve(http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "index a/b")
+	assert.Contains(t, w.Body.String(), "boom")
+	assert.Contains(t, w.Body.String(), `action="breakers/index%20a%2Fb/open"`)
+
+	w = serve(http.MethodPost, "/", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandler_IndexDryRun(t *testing.T) {
+	b := breaker.NewBreaker(breaker.WithName("index dry run"), breaker.WithDryRun())
+	breaker.Register(b)
+	defer breaker.Unregister(b.Name())
+
+	w := serve(http.MethodGet, "/", nil)
+	assert.Contains(t, w.Body.String(), "(dry run, 0 would drop)")
+}
+
+func TestHandler_List(t *testing.T) {
+	register(t, "list")
+
+	w := serve(http.MethodGet, "/breakers", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list []breaker.Stats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	var found bool
+	for _, stats := range list {
+		if stats.Name == "list" {
+			found = true
+			assert.Equal(t, breaker.StateAuto, stats.State)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestHandler_Breaker(t *testing.T) {
+	register(t, "breaker a/b")
+
+	w := serve(http.MethodGet, "/breakers/"+url.PathEscape("breaker a/b"), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	stats := decodeStats(t, w)
+	assert.Equal(t, "breaker a/b", stats.Name)
+
+	w = serve(http.MethodGet, "/breakers/not-exists", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(http.MethodGet, "/any", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(http.MethodGet, "/breakers/a/b/c", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandler_Actions(t *testing.T) {
+	b := register(t, "actions")
+
+	w := serve(http.MethodPost, "/breakers/actions/open", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, breaker.StateForcedOpen, decodeStats(t, w).State)
+	_, err := b.Allow()
+	assert.ErrorIs(t, err, breaker.ErrServiceUnavailable)
+
+	w = serve(http.MethodPost, "/breakers/actions/close", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, breaker.StateForcedClosed, decodeStats(t, w).State)
+
+	w = serve(http.MethodPost, "/breakers/actions/auto", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, breaker.StateAuto, decodeStats(t, w).State)
+
+	allow, err := b.Allow()
+	assert.NoError(t, err)
+	allow.Reject("any")
+	w = serve(http.MethodPost, "/breakers/actions/reset", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(0), decodeStats(t, w).Total)
+
+	w = serve(http.MethodPost, "/breakers/actions/any", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(http.MethodPost, "/breakers/not-exists/open", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(http.MethodGet, "/breakers/actions/open", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandler_Tune(t *testing.T) {
+	register(t, "tune")
+
+	w := serve(http.MethodPost, "/breakers/tune/tune", url.Values{"k": {"2"}, "protection": {"10"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	stats := decodeStats(t, w)
+	assert.Equal(t, float64(2), stats.Params["k"])
+	assert.Equal(t, float64(10), stats.Params["protection"])
+
+	w = serve(http.MethodPost, "/breakers/tune/tune", url.Values{"k": {"any"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(http.MethodPost, "/breakers/tune/tune", url.Values{"any": {"1"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), breaker.ErrUnknownParam.Error())
+}
+
+func TestHandler_HTMLRedirect(t *testing.T) {
+	register(t, "redirect")
+
+	r := httptest.NewRequest(http.MethodPost, "/breakers/redirect/open", nil)
+	r.Header.Set("Accept", "text/html")
+	r.Header.Set("Origin", "http://"+r.Host)
+	w := httptest.NewRecorder()
+	NewHandler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "../../", w.Header().Get("Location"))
+}
+
+func TestHandler_CrossSite(t *testing.T) {
+	register(t, "cross-site")
+
+	for origin, code := range map[string]int{
+		"":                   http.StatusForbidden,
+		"null":               http.StatusForbidden,
+		"http://evil.com":    http.StatusForbidden,
+		"http://example.com": http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/breakers/cross-site/open", strings.NewReader("k=2"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(origin) > 0 {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		NewHandler().ServeHTTP(w, r)
+		assert.Equal(t, code, w.Code, origin)
+	}
+}
+
+func TestHandler_NotControllable(t *testing.T) {
+	breaker.Register(mockedBreaker{})
+	t.Cleanup(func() {
+		breaker.Unregister(mockedBreaker{}.Name())
+	})
+
+	w := serve(http.MethodGet, "/breakers/mocked", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mocked", decodeStats(t, w).Name)
+
+	w = serve(http.MethodPost, "/breakers/mocked/open", nil)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func register(t *testing.T, name string) breaker.Breaker {
+	b := breaker.NewBreaker(breaker.WithName(name))
+	breaker.Register(b)
+	t.Cleanup(func() {
+		breaker.Unregister(name)
+	})
+
+	return b
+}
+
+func serve(method, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	r.Header.Set(requestedWithHeader, "test")
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	NewHandler().ServeHTTP(w, r)
+
+	return w
+}
+
+func decodeStats(t *testing.T, w *httptest.ResponseRecorder) breaker.Stats {
+	var stats breaker.Stats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+
+	return stats
+}
+
+type mockedBreaker struct{}
+
+func (m mockedBreaker) Name() string {
+	return "mocked"
+}
+
+func (m mockedBreaker) Allow() (breaker.Promise, error) {
+	return nil, breaker.ErrServiceUnavailable
+}
@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+)
+
+const (
+	// StatusHealthy means the breaker drops no more than the degraded ratio of calls.
+	StatusHealthy Status = "healthy"
+	// StatusDegraded means the breaker drops more than the degraded ratio of calls,
+	// or more than the unhealthy ratio while in dry run or warming up.
+	StatusDegraded Status = "degraded"
+	// StatusUnhealthy means the breaker drops more than the unhealthy ratio of calls, or is forced open.
+	StatusUnhealthy Status = "unhealthy"
+
+	defaultDegradedRatio  = 0.1
+	defaultUnhealthyRatio = 0.5
+)
+
+type (
+	// Status is the health status of a breaker.
+	Status string
+
+	// HealthOption defines the method to customize a HealthChecker.
+	HealthOption func(c *HealthChecker)
+
+	// HealthChecker tells the health of the registered breakers by their drop ratios.
+	// It's an http.Handler that serves the Health as JSON, with 503 if unhealthy, which suits readiness probes,
+	// and Check suits the health libraries that take a func(ctx) error.
+	HealthChecker struct {
+		degraded  float64
+		unhealthy float64
+	}
+
+	// Health is the health of all registered breakers, the status is the worst of them.
+	Health struct {
+		Status   Status          `json:"status"`
+		Breakers []BreakerHealth `json:"breakers"`
+	}
+
+	// BreakerHealth is the health of a breaker along with its statistics.
+	BreakerHealth struct {
+		Status Status `json:"status"`
+		breaker.Stats
+	}
+)
+
+// NewHealthChecker returns a HealthChecker that reports a breaker degraded if it drops more than 10% of calls,
+// and unhealthy if it drops more than 50%. Use opts to customize the thresholds.
+func NewHealthChecker(opts ...HealthOption) *HealthChecker {
+	c := &HealthChecker{
+		degraded:  defaultDegradedRatio,
+		unhealthy: defaultUnhealthyRatio,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithDegradedRatio returns a HealthOption to report a breaker degraded if its drop ratio is over ratio.
+func WithDegradedRatio(ratio float64) HealthOption {
+	return func(c *HealthChecker) {
+		c.degraded = ratio
+	}
+}
+
+// WithUnhealthyRatio returns a HealthOption to report a breaker unhealthy if its drop ratio is over ratio.
+func WithUnhealthyRatio(ratio float64) HealthOption {
+	return func(c *HealthChecker) {
+		c.unhealthy = ratio
+	}
+}
+
+// Health returns the health of all registered breakers.
+func (c *HealthChecker) Health() Health {
+	stats := listStats()
+	health := Health{
+		Status:   StatusHealthy,
+		Breakers: make([]BreakerHealth, 0, len(stats)),
+	}
+	for _, s := range stats {
+		status := c.status(s)
+		if worse(status, health.Status) {
+			health.Status = status
+		}
+		health.Breakers = append(health.Breakers, BreakerHealth{Status: status, Stats: s})
+	}
+
+	return health
+}
+
+// Check returns an error naming the unhealthy breakers if any, the degraded ones are taken as healthy.
+func (c *HealthChecker) Check(_ context.Context) error {
+	var names []string
+	for _, b := range c.Health().Breakers {
+		if b.Status == StatusUnhealthy {
+			names = append(names, b.Name)
+		}
+	}
+
+	if len(names) > 0 {
+		return fmt.Errorf("unhealthy breakers: %s", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+func (c *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	health := c.Health()
+	code := http.StatusOK
+	if health.Status == StatusUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, health)
+}
+
+func (c *HealthChecker) status(stats breaker.Stats) Status {
+	var status Status
+	switch {
+	case stats.State == breaker.StateForcedOpen:
+		return StatusUnhealthy
+	case stats.DropRatio > c.unhealthy:
+		status = StatusUnhealthy
+	case stats.DropRatio > c.degraded:
+		status = StatusDegraded
+	default:
+		return StatusHealthy
+	}
+
+	// the breakers in dry run drop nothing, they only tell the trouble.
+	// the breakers warming up are recovering, they need the calls to ramp up.
+	if stats.DryRun || stats.Warmup > 0 {
+		return StatusDegraded
+	}
+
+	return status
+}
+
+// worse reports whether a is worse than b.
+func worse(a, b Status) bool {
+	rank := func(s Status) int {
+		switch s {
+		case StatusUnhealthy:
+			return 2
+		case StatusDegraded:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return rank(a) > rank(b)
+}
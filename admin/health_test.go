@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+// registerShedding registers a breaker that is shedding requests, as the time doesn't go by.
+func registerShedding(t *testing.T, name string, opts ...breaker.Option) breaker.Breaker {
+	b := breaker.NewBreaker(append([]breaker.Option{
+		breaker.WithName(name),
+		breaker.WithClock(timex.NewManualClock(0)),
+	}, opts...)...)
+	for i := 0; i < 100; i++ {
+		if p, err := b.Allow(); err == nil {
+			p.Reject("boom")
+		}
+	}
+	breaker.Register(b)
+	t.Cleanup(func() {
+		breaker.Unregister(name)
+	})
+
+	return b
+}
+
+func healthOf(health Health, name string) BreakerHealth {
+	for _, b := range health.Breakers {
+		if b.Name == name {
+			return b
+		}
+	}
+
+	return BreakerHealth{}
+}
+
+func TestHealthChecker_Health(t *testing.T) {
+	register(t, "health ok")
+	c := NewHealthChecker()
+	health := c.Health()
+	assert.Equal(t, StatusHealthy, health.Status)
+	assert.Equal(t, StatusHealthy, healthOf(health, "health ok").Status)
+	assert.NoError(t, c.Check(context.Background()))
+
+	registerShedding(t, "health shedding")
+	health = c.Health()
+	assert.Equal(t, StatusUnhealthy, health.Status)
+	assert.Equal(t, StatusHealthy, healthOf(health, "health ok").Status)
+	shedding := healthOf(health, "health shedding")
+	assert.Equal(t, StatusUnhealthy, shedding.Status)
+	assert.Contains(t, shedding.Reasons[0], "boom")
+	assert.EqualError(t, c.Check(context.Background()), "unhealthy breakers: health shedding")
+
+	health = NewHealthChecker(WithUnhealthyRatio(0.99)).Health()
+	assert.Equal(t, StatusDegraded, health.Status)
+	assert.Equal(t, StatusDegraded, healthOf(health, "health shedding").Status)
+
+	health = NewHealthChecker(WithDegradedRatio(0.99), WithUnhealthyRatio(0.99)).Health()
+	assert.Equal(t, StatusHealthy, health.Status)
+}
+
+func TestHealthChecker_Status(t *testing.T) {
+	b := register(t, "health forced")
+	b.(breaker.Controller).Force(breaker.StateForcedOpen)
+	assert.Equal(t, StatusUnhealthy, healthOf(NewHealthChecker().Health(), "health forced").Status)
+
+	registerShedding(t, "health dry run", breaker.WithDryRun())
+	assert.Equal(t, StatusDegraded, healthOf(NewHealthChecker().Health(), "health dry run").Status)
+}
+
+func TestHealthChecker_Warmup(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := registerShedding(t, "health warmup", breaker.WithClock(clock), breaker.WithSlowStart(time.Minute, breaker.RampLinear))
+	assert.Equal(t, StatusUnhealthy, healthOf(NewHealthChecker().Health(), "health warmup").Status)
+
+	// the statistics expire, and the next call starts the slow start.
+	clock.Advance(time.Minute)
+	_, _ = b.Allow()
+	health := healthOf(NewHealthChecker().Health(), "health warmup")
+	assert.True(t, health.Warmup > 0)
+	assert.True(t, health.DropRatio > defaultUnhealthyRatio)
+	assert.Equal(t, StatusDegraded, health.Status)
+
+	w := serve(http.MethodGet, "/health", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHealthChecker_ServeHTTP(t *testing.T) {
+	register(t, "health http")
+	w := serve(http.MethodGet, "/health", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var health Health
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, StatusHealthy, health.Status)
+	assert.Equal(t, StatusHealthy, healthOf(health, "health http").Status)
+
+	registerShedding(t, "health http shedding")
+	w = serve(http.MethodGet, "/health", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"unhealthy","name":"health http shedding"`)
+
+	w = httptest.NewRecorder()
+	NewHealthChecker().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
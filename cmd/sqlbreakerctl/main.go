@@ -0,0 +1,27 @@
+// Command sqlbreakerctl inspects and operates breakers over the admin endpoint.
+//
+// Usage:
+//
+//	sqlbreakerctl [flags] list
+//	sqlbreakerctl [flags] show NAME
+//	sqlbreakerctl [flags] watch [NAME...]
+//	sqlbreakerctl [flags] open|close|auto|reset NAME
+//	sqlbreakerctl [flags] tune NAME PARAM=VALUE...
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "sqlbreakerctl:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/admin"
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+)
+
+const (
+	defaultAddr = "http://localhost:6060/debug/breakers"
+	envAddr     = "SQLBREAKER_ADDR"
+
+	outputTable = "table"
+	outputJSON  = "json"
+
+	clearScreen = "\033[H\033[2J"
+	barWidth    = 20
+)
+
+var errUsage = errors.New("usage: sqlbreakerctl [flags] list|show|watch|open|close|auto|reset|tune [NAME] [PARAM=VALUE...]")
+
+// positiveDuration is a duration flag that rejects the durations not greater than 0.
+type positiveDuration struct {
+	d *time.Duration
+}
+
+func (p positiveDuration) String() string {
+	if p.d == nil {
+		return ""
+	}
+
+	return p.d.String()
+}
+
+func (p positiveDuration) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return errors.New("must be positive")
+	}
+
+	*p.d = d
+	return nil
+}
+
+type config struct {
+	addr     string
+	output   string
+	interval time.Duration
+	count    int
+	timeout  time.Duration
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	c := config{
+		interval: time.Second,
+		timeout:  time.Second * 5,
+	}
+	fs := flag.NewFlagSet("sqlbreakerctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.addr, "addr", addrFromEnv(), "base url of the admin endpoint, defaults to $"+envAddr)
+	fs.StringVar(&c.output, "o", outputTable, "output format, table or json")
+	fs.Var(positiveDuration{&c.interval}, "interval", "`duration` between the refreshes of watch")
+	fs.IntVar(&c.count, "n", 0, "number of refreshes of watch, 0 means until interrupted")
+	fs.Var(positiveDuration{&c.timeout}, "timeout", "`duration` to wait for each request")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if c.output != outputTable && c.output != outputJSON {
+		return fmt.Errorf("unknown output format %q", c.output)
+	}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	client := admin.NewClient(c.addr, nil)
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		return list(ctx, c, client, stdout)
+	case "show":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return show(ctx, c, client, args[0], stdout)
+	case "watch":
+		return watch(ctx, c, client, args, stdout)
+	case "open", "close", "auto", "reset":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return operate(ctx, c, client, cmd, args[0], stdout)
+	case "tune":
+		if len(args) < 2 {
+			return errUsage
+		}
+		return tune(ctx, c, client, args[0], args[1:], stdout)
+	default:
+		return errUsage
+	}
+}
+
+func list(ctx context.Context, c config, client *admin.Client, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stats, err := client.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	return printList(c, stats, w)
+}
+
+func show(ctx context.Context, c config, client *admin.Client, name string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stats, err := client.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return printStats(c, stats, w)
+}
+
+func watch(ctx context.Context, c config, client *admin.Client, names []string, w io.Writer) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for i := 0; c.count <= 0 || i < c.count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+
+		stats, err := watchOnce(ctx, c, client, names)
+		if ctx.Err() != nil {
+			// interrupted
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if c.output == outputJSON {
+			if err = json.NewEncoder(w).Encode(stats); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Fprint(w, clearScreen)
+		fmt.Fprintf(w, "%s  %s\n\n", c.addr, time.Now().Format("15:04:05"))
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSTATE\tTOTAL\tDROP RATIO\t")
+		for _, s := range stats {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s %6.2f%%\t\n", s.Name, s.State, s.Total, bar(s.DropRatio), s.DropRatio*100)
+		}
+		if err = tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func watchOnce(ctx context.Context, c config, client *admin.Client, names []string) ([]breaker.Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stats, err := client.List(ctx)
+	if err != nil || len(names) == 0 {
+		return stats, err
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var filtered []breaker.Stats
+	for _, s := range stats {
+		if wanted[s.Name] {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered, nil
+}
+
+func operate(ctx context.Context, c config, client *admin.Client, cmd, name string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var (
+		stats breaker.Stats
+		err   error
+	)
+	switch cmd {
+	case "open":
+		stats, err = client.Force(ctx, name, breaker.StateForcedOpen)
+	case "close":
+		stats, err = client.Force(ctx, name, breaker.StateForcedClosed)
+	case "auto":
+		stats, err = client.Force(ctx, name, breaker.StateAuto)
+	default:
+		stats, err = client.Reset(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	return printStats(c, stats, w)
+}
+
+func tune(ctx context.Context, c config, client *admin.Client, name string, args []string, w io.Writer) error {
+	params := make(map[string]float64, len(args))
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid parameter %q, want PARAM=VALUE", arg)
+		}
+
+		value, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid parameter %q: %w", arg, err)
+		}
+		params[kv[0]] = value
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stats, err := client.Tune(ctx, name, params)
+	if err != nil {
+		return err
+	}
+
+	return printStats(c, stats, w)
+}
+
+func printList(c config, stats []breaker.Stats, w io.Writer) error {
+	if c.output == outputJSON {
+		return printJSON(stats, w)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tACCEPTS\tTOTAL\tDROP RATIO\tPARAMS\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.4f\t%s\t\n", s.Name, s.State, s.Accepts, s.Total, s.DropRatio, formatParams(s.Params))
+	}
+
+	return tw.Flush()
+}
+
+func printStats(c config, s breaker.Stats, w io.Writer) error {
+	if c.output == outputJSON {
+		return printJSON(s, w)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s\n", s.Name)
+	fmt.Fprintf(tw, "State:\t%s\n", s.State)
+	fmt.Fprintf(tw, "Accepts:\t%d\n", s.Accepts)
+	fmt.Fprintf(tw, "Total:\t%d\n", s.Total)
+	fmt.Fprintf(tw, "Drop ratio:\t%.4f\n", s.DropRatio)
+	if s.DryRun {
+		fmt.Fprintf(tw, "Would drop:\t%d (dry run)\n", s.WouldDrop)
+	}
+	if s.InFlight > 0 || s.QueueTime.Count > 0 {
+		fmt.Fprintf(tw, "In flight:\t%d\n", s.InFlight)
+		fmt.Fprintf(tw, "Queue time:\t%s\n", formatLatency(s.QueueTime))
+	}
+	if s.WaitTime.Count > 0 || s.WaitTimeouts > 0 {
+		fmt.Fprintf(tw, "Wait time:\t%s (%d timed out)\n", formatLatency(s.WaitTime), s.WaitTimeouts)
+	}
+	if s.Warmup > 0 {
+		fmt.Fprintf(tw, "Warming up:\t%.0f%%\n", s.Warmup*100)
+	}
+	if s.Overloaded {
+		fmt.Fprintln(tw, "Overloaded:\tyes, shedding the queue in LIFO order")
+	}
+	fmt.Fprintf(tw, "Params:\t%s\n", formatParams(s.Params))
+	fmt.Fprintf(tw, "Latency:\t%s\n", formatLatency(s.Latency))
+	for _, op := range sortedKeys(s.OpLatency) {
+		fmt.Fprintf(tw, "  %s:\t%s\n", op, formatLatency(s.OpLatency[op]))
+	}
+	fmt.Fprintln(tw, "Reasons:\t")
+	for _, reason := range s.Reasons {
+		fmt.Fprintf(tw, "\t%s\n", reason)
+	}
+
+	return tw.Flush()
+}
+
+func printJSON(v interface{}, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+func formatLatency(l breaker.LatencyStats) string {
+	return fmt.Sprintf("count=%d mean=%s p50=%s p95=%s p99=%s max=%s", l.Count, l.Mean, l.P50, l.P95, l.P99, l.Max)
+}
+
+func sortedKeys(m map[string]breaker.LatencyStats) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatParams(params map[string]float64) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+strconv.FormatFloat(params[key], 'g', -1, 64))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func bar(ratio float64) string {
+	n := int(ratio*barWidth + 0.5)
+	if n < 0 {
+		n = 0
+	} else if n > barWidth {
+		n = barWidth
+	}
+
+	return "[" + strings.Repeat("#", n) + strings.Repeat(" ", barWidth-n) + "]"
+}
+
+func addrFromEnv() string {
+	if addr := os.Getenv(envAddr); len(addr) > 0 {
+		return addr
+	}
+
+	return defaultAddr
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/admin"
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	b := breaker.NewBreaker(breaker.WithName("ctl"))
+	breaker.Register(b)
+	defer breaker.Unregister(b.Name())
+	allow, err := b.Allow()
+	assert.NoError(t, err)
+	allow.Reject("boom")
+
+	svr := httptest.NewServer(admin.NewHandler())
+	defer svr.Close()
+
+	tests := []struct {
+		name   string
+		args   []string
+		expect []string
+	}{
+		{
+			name:   "list",
+			args:   []string{"list"},
+			expect: []string{"NAME", "ctl", "auto", "k=1.5,protection=5"},
+		},
+		{
+			name:   "show",
+			args:   []string{"show", "ctl"},
+			expect: []string{"Name:", "ctl", "boom", "Latency:", "count=0"},
+		},
+		{
+			name:   "open",
+			args:   []string{"open", "ctl"},
+			expect: []string{"forced-open"},
+		},
+		{
+			name:   "close",
+			args:   []string{"close", "ctl"},
+			expect: []string{"forced-closed"},
+		},
+		{
+			name:   "auto",
+			args:   []string{"auto", "ctl"},
+			expect: []string{"auto"},
+		},
+		{
+			name:   "tune",
+			args:   []string{"tune", "ctl", "k=2", "protection=10"},
+			expect: []string{"k=2,protection=10"},
+		},
+		{
+			name:   "reset",
+			args:   []string{"-o", "json", "reset", "ctl"},
+			expect: []string{`"name": "ctl"`, `"total": 0`},
+		},
+		{
+			name:   "watch",
+			args:   []string{"-n", "2", "-interval", "1ms", "watch", "ctl", "any"},
+			expect: []string{clearScreen, "DROP RATIO", "ctl"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			err := run(context.Background(), append([]string{"-addr", svr.URL}, test.args...), &stdout, &stderr)
+			assert.NoError(t, err)
+			for _, expect := range test.expect {
+				assert.Contains(t, stdout.String(), expect)
+			}
+		})
+	}
+}
+
+func TestRun_JSON(t *testing.T) {
+	b := breaker.NewBreaker(breaker.WithName("ctl-json"))
+	breaker.Register(b)
+	defer breaker.Unregister(b.Name())
+
+	svr := httptest.NewServer(admin.NewHandler())
+	defer svr.Close()
+
+	var stdout bytes.Buffer
+	err := run(context.Background(), []string{"-addr", svr.URL, "-o", "json", "list"}, &stdout, new(bytes.Buffer))
+	assert.NoError(t, err)
+	var list []breaker.Stats
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &list))
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"-addr", svr.URL, "-o", "json", "-n", "2", "-interval", "1ms", "watch", "ctl-json"}, &stdout, new(bytes.Buffer))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.NoError(t, json.Unmarshal([]byte(line), &list))
+		assert.Len(t, list, 1)
+	}
+}
+
+func TestRun_WatchCanceled(t *testing.T) {
+	svr := httptest.NewServer(admin.NewHandler())
+	defer svr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err := run(ctx, []string{"-addr", svr.URL, "-interval", "1ms", "watch"}, new(bytes.Buffer), new(bytes.Buffer))
+	assert.NoError(t, err)
+}
+
+func TestRun_Errors(t *testing.T) {
+	svr := httptest.NewServer(admin.NewHandler())
+	defer svr.Close()
+
+	tests := [][]string{
+		{},
+		{"any"},
+		{"show"},
+		{"open"},
+		{"tune", "ctl"},
+		{"tune", "ctl", "k"},
+		{"tune", "ctl", "k=any"},
+		{"show", "not-exists"},
+		{"-o", "yaml", "list"},
+		{"-unknown"},
+		{"-interval", "0", "watch"},
+		{"-interval", "-1s", "watch"},
+		{"-timeout", "0", "list"},
+	}
+
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			err := run(context.Background(), append([]string{"-addr", svr.URL}, args...), new(bytes.Buffer), new(bytes.Buffer))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBar(t *testing.T) {
+	assert.Equal(t, "["+strings.Repeat(" ", barWidth)+"]", bar(0))
+	assert.Equal(t, "["+strings.Repeat("#", barWidth/2)+strings.Repeat(" ", barWidth/2)+"]", bar(0.5))
+	assert.Equal(t, "["+strings.Repeat("#", barWidth)+"]", bar(2))
+}
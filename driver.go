@@ -1,16 +1,41 @@
 package sqlbreaker
 
 import (
+	"context"
 	"database/sql/driver"
 
 	"github.com/chenquan/sqlbreaker/pkg/breaker"
 	"github.com/chenquan/sqlplus"
 )
 
-func NewDriver(b breaker.Breaker, d driver.Driver) driver.Driver {
-	return sqlplus.New(d, NewBreakerHook(b))
+// dsnConnector is the driver.Connector of the drivers that don't implement driver.DriverContext.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func NewDriver(b breaker.Breaker, d driver.Driver, opts ...HookOption) driver.Driver {
+	return sqlplus.New(d, NewBreakerHook(b, opts...))
 }
 
 func NewDefaultDriver(d driver.Driver) driver.Driver {
 	return sqlplus.New(d, NewBreakerHook(breaker.NewBreaker()))
 }
+
+// openConnector returns a driver.Connector that opens the connections to dsn with d guarded by b.
+func openConnector(b breaker.Breaker, d driver.Driver, dsn string, opts ...HookOption) (driver.Connector, error) {
+	wrapped := NewDriver(b, d, opts...)
+	if dc, ok := wrapped.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+
+	return dsnConnector{dsn: dsn, driver: wrapped}, nil
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -1,6 +1,8 @@
 package sqlbreaker
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
 	"testing"
 
@@ -20,3 +22,36 @@ func TestNewBreakerHook(t *testing.T) {
 	hook := NewBreakerHook(breaker.Breaker(nil))
 	assert.Equal(t, &Hook{brk: breaker.Breaker(nil)}, hook)
 }
+
+func TestNewDriver_Nested(t *testing.T) {
+	// the mocked connections can't exec or query directly, sqlplus runs a prepare and a statement inside.
+	b := breaker.NewBulkhead(1, 0)
+	connector, err := openConnector(b, &mockedDriver{}, "dsn")
+	assert.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	inFlight := func() int64 {
+		return b.(breaker.Inspector).Stats().InFlight
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err = db.ExecContext(ctx, "delete from t")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), inFlight())
+		mustQuery(t, ctx, db, "select * from t")
+		assert.Equal(t, int64(0), inFlight())
+	}
+
+	// the dropped calls don't give back the slot that they don't hold.
+	p, err := b.Allow()
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, "delete from t")
+	assert.ErrorIs(t, err, breaker.ErrBulkheadFull)
+	assert.Equal(t, int64(1), inFlight())
+	p.Accept()
+	assert.Equal(t, int64(0), inFlight())
+	_, err = db.ExecContext(ctx, "delete from t")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), inFlight())
+}
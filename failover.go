@@ -0,0 +1,436 @@
+package sqlbreaker
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+// ratioInterval is how long the sampled drop ratios of the replicas are used, as the stats are not cheap.
+const ratioInterval = time.Millisecond * 250
+
+var (
+	_ driver.Connector          = (*failoverConnector)(nil)
+	_ driver.ConnPrepareContext = (*failoverConn)(nil)
+	_ driver.ConnBeginTx        = (*failoverConn)(nil)
+	_ driver.ExecerContext      = (*failoverConn)(nil)
+	_ driver.QueryerContext     = (*failoverConn)(nil)
+)
+
+// shedErrors are the errors that the breakers drop the calls with, rather than the database fails them.
+var shedErrors = []error{
+	breaker.ErrServiceUnavailable,
+	breaker.ErrBulkheadFull,
+	breaker.ErrQueueTimeout,
+	breaker.ErrLimitExceeded,
+	breaker.ErrRateLimited,
+	ErrWaitTimeout,
+}
+
+type (
+	// A Target is a database that a failover connector routes the statements to, guarded by its own breaker.
+	Target struct {
+		// Driver is the driver of the database.
+		Driver driver.Driver
+		// DSN is the data source name of the database.
+		DSN string
+		// Breaker guards the statements to the database.
+		Breaker breaker.Breaker
+	}
+
+	failoverConnector struct {
+		// targets are the primary followed by the replicas.
+		targets []driver.Connector
+		brks    []breaker.Breaker
+		// next rotates the replicas that are equally healthy.
+		next uint64
+		// ratios holds the *sampledRatios of the targets.
+		ratios atomic.Value
+	}
+
+	// sampledRatios are the drop ratios of the targets sampled at time at.
+	sampledRatios struct {
+		at     time.Duration
+		ratios []float64
+	}
+
+	// failoverConn is a connection to all targets, the ones to each target are opened on first use.
+	// It's not used concurrently, as database/sql holds a connection for one call at a time.
+	failoverConn struct {
+		c     *failoverConnector
+		conns []hookedConn
+		// tx is the target of the running transaction, -1 if none.
+		tx int
+	}
+
+	failoverTx struct {
+		driver.Tx
+		conn *failoverConn
+	}
+
+	// hookedConn is the connection that sqlplus returns, which implements the optional interfaces in any case.
+	hookedConn interface {
+		driver.Conn
+		driver.ConnPrepareContext
+		driver.ConnBeginTx
+		driver.ExecerContext
+		driver.QueryerContext
+	}
+
+	primaryKey struct{}
+)
+
+// NewFailoverConnector returns a driver.Connector over a primary and its read replicas, each guarded by its breaker,
+// pass it to sql.OpenDB. The writes go to the primary, while the read-only statements and transactions go to
+// the healthiest replica, that is the one with the lowest drop ratio, and fail over to the next healthiest one
+// if a breaker drops them, the primary being the last resort. opts customize the hooks of all targets.
+// The drop ratios are sampled every 250ms, so the changes of the breakers take effect with that delay.
+//
+// A statement is read-only if it's a SELECT, but not a SELECT ... FOR UPDATE, or a SHOW, DESCRIBE or EXPLAIN,
+// a transaction is read-only if begun with sql.TxOptions.ReadOnly, and the statements in a transaction
+// or of a prepared statement stick to the target they began on. Use UsePrimary to read from the primary.
+//
+// A connection of the sql.DB holds a connection to each target it has used, so the limits like SetMaxOpenConns
+// apply to each target rather than to all of them. A target whose connection returns driver.ErrBadConn
+// is reconnected on next use, the reads fail over meanwhile, while the other calls return driver.ErrBadConn,
+// which makes database/sql discard the connections to all targets.
+func NewFailoverConnector(primary Target, replicas []Target, opts ...HookOption) (driver.Connector, error) {
+	c := &failoverConnector{}
+	for _, target := range append([]Target{primary}, replicas...) {
+		connector, err := openConnector(target.Breaker, target.Driver, target.DSN, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		c.targets = append(c.targets, connector)
+		c.brks = append(c.brks, target.Breaker)
+	}
+
+	return c, nil
+}
+
+// UsePrimary returns a copy of ctx that routes the read-only statements to the primary,
+// e.g. to read the writes just made.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, struct{}{})
+}
+
+func (c *failoverConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return &failoverConn{
+		c:     c,
+		conns: make([]hookedConn, len(c.targets)),
+		tx:    -1,
+	}, nil
+}
+
+func (c *failoverConnector) Driver() driver.Driver {
+	return c.targets[0].Driver()
+}
+
+// readOrder returns the targets from the healthiest replica to the least healthy one, followed by the primary.
+// The equally healthy replicas take turns to come first.
+func (c *failoverConnector) readOrder() []int {
+	replicas := len(c.targets) - 1
+	order := make([]int, 0, len(c.targets))
+	if replicas > 0 {
+		ratios := c.dropRatios()
+		offset := int(atomic.AddUint64(&c.next, 1) % uint64(replicas))
+		for i := 0; i < replicas; i++ {
+			order = append(order, 1+(offset+i)%replicas)
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return ratios[order[i]] < ratios[order[j]]
+		})
+	}
+
+	return append(order, 0)
+}
+
+// dropRatios returns the drop ratios of the targets, sampled once a ratioInterval.
+func (c *failoverConnector) dropRatios() []float64 {
+	now := timex.Now()
+	if sampled, ok := c.ratios.Load().(*sampledRatios); ok && now-sampled.at < ratioInterval {
+		return sampled.ratios
+	}
+
+	// the goroutines that race to sample store the same ratios, whichever is kept.
+	ratios := make([]float64, len(c.brks))
+	for i, b := range c.brks {
+		ratios[i] = dropRatio(b)
+	}
+	c.ratios.Store(&sampledRatios{at: now, ratios: ratios})
+
+	return ratios
+}
+
+func (c *failoverConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *failoverConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	err = c.route(ctx, readOnly(query), func(_ int, conn hookedConn) error {
+		stmt, err = conn.PrepareContext(ctx, query)
+		return err
+	})
+
+	return stmt, err
+}
+
+func (c *failoverConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *failoverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	err = c.route(ctx, opts.ReadOnly, func(i int, conn hookedConn) error {
+		if tx, err = conn.BeginTx(ctx, opts); err != nil {
+			return err
+		}
+
+		c.tx = i
+		tx = &failoverTx{Tx: tx, conn: c}
+		return nil
+	})
+
+	return tx, err
+}
+
+func (c *failoverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (result driver.Result, err error) {
+	err = c.route(ctx, false, func(_ int, conn hookedConn) error {
+		result, err = conn.ExecContext(ctx, query, args)
+		return err
+	})
+
+	return result, err
+}
+
+func (c *failoverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	err = c.route(ctx, readOnly(query), func(_ int, conn hookedConn) error {
+		rows, err = conn.QueryContext(ctx, query, args)
+		return err
+	})
+
+	return rows, err
+}
+
+func (c *failoverConn) Close() error {
+	var err error
+	for i, conn := range c.conns {
+		if conn == nil {
+			continue
+		}
+
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		c.conns[i] = nil
+	}
+
+	return err
+}
+
+// route calls do with the connection to the target that the call goes to.
+// The read-only calls fail over to the next target if the breaker drops them, the target is unreachable,
+// or its connection is bad.
+func (c *failoverConn) route(ctx context.Context, read bool, do func(target int, conn hookedConn) error) error {
+	if c.tx >= 0 {
+		return c.call(ctx, c.tx, do)
+	}
+	if !read || ctx.Value(primaryKey{}) != nil {
+		return c.call(ctx, 0, do)
+	}
+
+	var err error
+	for _, target := range c.c.readOrder() {
+		conn, connErr := c.conn(ctx, target)
+		if connErr != nil {
+			err = connErr
+			continue
+		}
+
+		err = do(target, conn)
+		if errors.Is(err, driver.ErrBadConn) {
+			c.drop(target)
+			continue
+		}
+		if !shed(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (c *failoverConn) call(ctx context.Context, target int, do func(target int, conn hookedConn) error) error {
+	conn, err := c.conn(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if err = do(target, conn); errors.Is(err, driver.ErrBadConn) {
+		c.drop(target)
+	}
+
+	return err
+}
+
+// conn returns the connection to target, it's opened if not yet.
+func (c *failoverConn) conn(ctx context.Context, target int) (hookedConn, error) {
+	if conn := c.conns[target]; conn != nil {
+		return conn, nil
+	}
+
+	conn, err := c.c.targets[target].Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the connections of sqlplus implement all the optional interfaces.
+	c.conns[target] = conn.(hookedConn)
+	return c.conns[target], nil
+}
+
+// drop closes the bad connection to target, which is opened again on next use.
+func (c *failoverConn) drop(target int) {
+	_ = c.conns[target].Close()
+	c.conns[target] = nil
+}
+
+func (t *failoverTx) Commit() error {
+	t.conn.tx = -1
+	return t.Tx.Commit()
+}
+
+func (t *failoverTx) Rollback() error {
+	t.conn.tx = -1
+	return t.Tx.Rollback()
+}
+
+// shed reports whether err tells that a breaker dropped the call.
+func shed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, target := range shedErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dropRatio returns the ratio of the calls that b drops, 0 if it can't tell.
+func dropRatio(b breaker.Breaker) float64 {
+	inspector, ok := b.(breaker.Inspector)
+	if !ok {
+		return 0
+	}
+
+	stats := inspector.Stats()
+	switch stats.State {
+	case breaker.StateForcedOpen:
+		return 1
+	case breaker.StateForcedClosed:
+		return 0
+	default:
+		return stats.DropRatio
+	}
+}
+
+// readOnly reports whether query only reads. Only the leading verb and the words telling the locking reads
+// are parsed, rather than the whole query.
+func readOnly(query string) bool {
+	verb, next := nextWord(query, 0)
+	switch {
+	case equalsAny(verb, "select"):
+		return !locking(query, next)
+	case equalsAny(verb, "show", "describe", "desc"):
+		return true
+	case equalsAny(verb, "explain"):
+		return explainsRead(query, next)
+	default:
+		return false
+	}
+}
+
+// locking reports whether the SELECT from i locks the rows or writes,
+// by FOR UPDATE, FOR NO KEY UPDATE, FOR SHARE, FOR KEY SHARE, LOCK IN SHARE MODE or INTO.
+func locking(query string, i int) bool {
+	var prev string
+	for word, next := nextWord(query, i); len(word) > 0; word, next = nextWord(query, next) {
+		switch {
+		case equalsAny(word, "into"):
+			return true
+		case equalsAny(prev, "for") && equalsAny(word, "update", "share", "no", "key"):
+			return true
+		case equalsAny(prev, "lock") && equalsAny(word, "in"):
+			return true
+		}
+		prev = word
+	}
+
+	return false
+}
+
+// explainsRead reports whether the EXPLAIN from i only reads,
+// which is not the case if it analyzes, thus runs, a statement that writes.
+func explainsRead(query string, i int) bool {
+	var analyze bool
+	for word, next := nextWord(query, i); len(word) > 0; word, next = nextWord(query, next) {
+		switch {
+		case equalsAny(word, "analyze", "analyse"):
+			analyze = true
+		case equalsAny(word, "select", "insert", "update", "delete", "replace", "merge", "with", "values", "table"):
+			return !analyze || readOnly(query[next-len(word):])
+		}
+	}
+
+	return !analyze
+}
+
+// nextWord returns the next keyword or identifier of query from i, and the index after it, empty if none.
+// The literals, quoted identifiers, comments and punctuation are skipped like in Fingerprint.
+func nextWord(query string, i int) (string, int) {
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"), c == '#':
+			i = skipUntil(query, i, "\n")
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipUntil(query, i+2, "*/")
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i, c)
+		case isDigit(c):
+			i = skipNumber(query, i)
+		case isIdent(c):
+			start := i
+			for i < len(query) && (isIdent(query[i]) || isDigit(query[i]) || query[i] == '$') {
+				i++
+			}
+			return query[start:i], i
+		default:
+			i++
+		}
+	}
+
+	return "", i
+}
+
+// equalsAny reports whether word is any of the lowercase words, regardless of the case.
+func equalsAny(word string, words ...string) bool {
+	for _, w := range words {
+		if len(word) == len(w) && strings.EqualFold(word, w) {
+			return true
+		}
+	}
+
+	return false
+}
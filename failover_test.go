@@ -0,0 +1,348 @@
+package sqlbreaker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	// mockedDriver records the DSN that served each statement.
+	mockedDriver struct {
+		lock   sync.Mutex
+		served []string
+		// down are the DSNs that can't be connected to.
+		down map[string]bool
+		// bad are the DSNs whose connections are bad, which fail with driver.ErrBadConn.
+		bad map[string]bool
+		// opened counts the connections opened to each DSN.
+		opened map[string]int
+	}
+
+	mockedConn struct {
+		d   *mockedDriver
+		dsn string
+	}
+
+	mockedStmt struct {
+		conn  *mockedConn
+		query string
+	}
+
+	mockedRows struct{}
+)
+
+func (d *mockedDriver) Open(name string) (driver.Conn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.down[name] {
+		return nil, errors.New("connection refused")
+	}
+
+	if d.opened == nil {
+		d.opened = make(map[string]int)
+	}
+	d.opened[name]++
+	return &mockedConn{d: d, dsn: name}, nil
+}
+
+func (d *mockedDriver) serve(dsn string) {
+	d.lock.Lock()
+	d.served = append(d.served, dsn)
+	d.lock.Unlock()
+}
+
+// take returns the DSNs that served the statements since the last call.
+func (d *mockedDriver) take() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	served := d.served
+	d.served = nil
+	return served
+}
+
+func (c *mockedConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.lock.Lock()
+	defer c.d.lock.Unlock()
+
+	if c.d.bad[c.dsn] {
+		return nil, driver.ErrBadConn
+	}
+
+	return &mockedStmt{conn: c, query: query}, nil
+}
+
+func (c *mockedConn) Close() error {
+	return nil
+}
+
+func (c *mockedConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *mockedConn) Commit() error {
+	return nil
+}
+
+func (c *mockedConn) Rollback() error {
+	return nil
+}
+
+func (s *mockedStmt) Close() error {
+	return nil
+}
+
+func (s *mockedStmt) NumInput() int {
+	return -1
+}
+
+func (s *mockedStmt) Exec(_ []driver.Value) (driver.Result, error) {
+	s.conn.d.serve(s.conn.dsn)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *mockedStmt) Query(_ []driver.Value) (driver.Rows, error) {
+	s.conn.d.serve(s.conn.dsn)
+	return mockedRows{}, nil
+}
+
+func (mockedRows) Columns() []string {
+	return nil
+}
+
+func (mockedRows) Close() error {
+	return nil
+}
+
+func (mockedRows) Next(_ []driver.Value) error {
+	return io.EOF
+}
+
+func openFailover(t *testing.T, d *mockedDriver, brks ...breaker.Breaker) *sql.DB {
+	var replicas []Target
+	for i, name := range []string{"replica1", "replica2"} {
+		replicas = append(replicas, Target{Driver: d, DSN: name, Breaker: brks[i+1]})
+	}
+	connector, err := NewFailoverConnector(Target{Driver: d, DSN: "primary", Breaker: brks[0]}, replicas)
+	assert.NoError(t, err)
+
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func mustQuery(t *testing.T, ctx context.Context, db *sql.DB, query string) {
+	rows, err := db.QueryContext(ctx, query)
+	assert.NoError(t, err)
+	if err == nil {
+		assert.NoError(t, rows.Close())
+	}
+}
+
+func TestFailoverConnector(t *testing.T) {
+	d := &mockedDriver{}
+	db := openFailover(t, d, breaker.NewBreaker(), breaker.NewBreaker(), breaker.NewBreaker())
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "insert into t values (?)", 1)
+	assert.NoError(t, err)
+	mustQuery(t, ctx, db, "select * from t for update")
+	assert.Equal(t, []string{"primary", "primary"}, d.take())
+
+	// the equally healthy replicas take turns.
+	mustQuery(t, ctx, db, "select * from t")
+	mustQuery(t, ctx, db, "SELECT * FROM t")
+	mustQuery(t, ctx, db, "show tables")
+	served := d.take()
+	assert.Len(t, served, 3)
+	assert.NotContains(t, served, "primary")
+	assert.Contains(t, served, "replica1")
+	assert.Contains(t, served, "replica2")
+
+	mustQuery(t, UsePrimary(ctx), db, "select * from t")
+	assert.Equal(t, []string{"primary"}, d.take())
+}
+
+func TestFailoverConnector_Failover(t *testing.T) {
+	d := &mockedDriver{down: map[string]bool{"replica2": true}}
+	shedding := breaker.NewBreaker()
+	shedding.(breaker.Controller).Force(breaker.StateForcedOpen)
+	db := openFailover(t, d, breaker.NewBreaker(), shedding, breaker.NewBreaker())
+	ctx := context.Background()
+
+	// replica1 sheds and replica2 is down, the primary is the last resort.
+	for i := 0; i < 3; i++ {
+		mustQuery(t, ctx, db, "select * from t")
+	}
+	assert.Equal(t, []string{"primary", "primary", "primary"}, d.take())
+
+	// the writes don't fail over.
+	primary := breaker.NewBreaker()
+	primary.(breaker.Controller).Force(breaker.StateForcedOpen)
+	db = openFailover(t, &mockedDriver{}, primary, breaker.NewBreaker(), breaker.NewBreaker())
+	_, err := db.ExecContext(ctx, "insert into t values (?)", 1)
+	assert.ErrorIs(t, err, breaker.ErrServiceUnavailable)
+}
+
+func TestFailoverConnector_Healthiest(t *testing.T) {
+	d := &mockedDriver{}
+	degraded := breaker.NewBreaker(breaker.WithClock(timex.NewManualClock(0)))
+	for i := 0; i < 100; i++ {
+		if p, err := degraded.Allow(); err == nil {
+			p.Reject("boom")
+		}
+	}
+	db := openFailover(t, d, breaker.NewBreaker(), degraded, breaker.NewBreaker())
+
+	for i := 0; i < 3; i++ {
+		mustQuery(t, context.Background(), db, "select * from t")
+	}
+	assert.Equal(t, []string{"replica2", "replica2", "replica2"}, d.take())
+}
+
+func TestFailoverConnector_BadConn(t *testing.T) {
+	d := &mockedDriver{bad: map[string]bool{"replica1": true}}
+	shedding := breaker.NewBreaker()
+	shedding.(breaker.Controller).Force(breaker.StateForcedOpen)
+	db := openFailover(t, d, breaker.NewBreaker(), breaker.NewBreaker(), shedding)
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "insert into t values (?)", 1)
+	assert.NoError(t, err)
+	// replica1 is bad and replica2 sheds, the read fails over to the primary without discarding its connection.
+	mustQuery(t, ctx, db, "select * from t")
+	_, err = db.ExecContext(ctx, "delete from t")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"primary", "primary", "primary"}, d.take())
+	assert.Equal(t, 1, d.opened["primary"])
+
+	// the bad replica is reconnected on next use.
+	d.lock.Lock()
+	d.bad = nil
+	d.lock.Unlock()
+	mustQuery(t, ctx, db, "select * from t")
+	assert.Equal(t, []string{"replica1"}, d.take())
+	assert.Equal(t, 2, d.opened["replica1"])
+	assert.Equal(t, 1, d.opened["primary"])
+}
+
+func TestFailoverConnector_Tx(t *testing.T) {
+	d := &mockedDriver{}
+	db := openFailover(t, d, breaker.NewBreaker(), breaker.NewBreaker(), breaker.NewBreaker())
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	rows, err := tx.QueryContext(ctx, "select * from t")
+	assert.NoError(t, err)
+	assert.NoError(t, rows.Close())
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, []string{"primary"}, d.take())
+
+	tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		rows, err = tx.QueryContext(ctx, "select * from t")
+		assert.NoError(t, err)
+		assert.NoError(t, rows.Close())
+	}
+	assert.NoError(t, tx.Rollback())
+	served := d.take()
+	assert.Len(t, served, 2)
+	assert.NotEqual(t, "primary", served[0])
+	assert.Equal(t, served[0], served[1])
+
+	// the connection is back to routing after the transaction.
+	_, err = db.ExecContext(ctx, "delete from t")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"primary"}, d.take())
+}
+
+func TestReadOnly(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"select * from t":                         true,
+		" /* hint */ SELECT 1":                    true,
+		"show tables":                             true,
+		"EXPLAIN select * from t":                 true,
+		"select * from t where id = 1 for update": false,
+		"select * from t lock in share mode":      false,
+		"select * into t2 from t":                 false,
+		"insert into t values (1)":                false,
+		"update t set a = 1":                      false,
+		"":                                        false,
+		// the locking reads of Postgres.
+		"SELECT * FROM t FOR NO KEY UPDATE":     false,
+		"select * from t for key share":         false,
+		"select * from t for share of t nowait": false,
+		"select * from t\nFOR\tUPDATE":          false,
+		// the words in literals, quoted identifiers and comments don't count.
+		"select * from t where a = 'for update'": true,
+		"select `into` from t -- for update":     true,
+		"select 1 /* into */":                    true,
+		// EXPLAIN ANALYZE runs the statement.
+		"explain select * from t":                       true,
+		"explain analyze select * from t":               true,
+		"EXPLAIN ANALYZE DELETE FROM t":                 false,
+		"explain (analyze, buffers) update t set a = 1": false,
+		"explain analyse select * from t for update":    false,
+		"explain insert into t values (1)":              true,
+	} {
+		assert.Equal(t, expected, readOnly(query), query)
+	}
+}
+
+func TestFailoverConnector_SampledRatios(t *testing.T) {
+	d := &mockedDriver{}
+	replica1 := breaker.NewBreaker()
+	db := openFailover(t, d, breaker.NewBreaker(), replica1, breaker.NewBreaker())
+	mustQuery(t, context.Background(), db, "select * from t")
+	d.take()
+
+	// the forced state takes effect on the next sample.
+	replica1.(breaker.Controller).Force(breaker.StateForcedOpen)
+	conn, err := db.Conn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Raw(func(driverConn interface{}) error {
+		c := driverConn.(*failoverConn).c
+		c.ratios.Load().(*sampledRatios).at -= ratioInterval
+		return nil
+	}))
+	assert.NoError(t, conn.Close())
+	for i := 0; i < 3; i++ {
+		mustQuery(t, context.Background(), db, "select * from t")
+	}
+	assert.Equal(t, []string{"replica2", "replica2", "replica2"}, d.take())
+}
+
+func BenchmarkFailoverConnector_ReadOrder(b *testing.B) {
+	c := &failoverConnector{}
+	for i := 0; i < 3; i++ {
+		c.targets = append(c.targets, nil)
+		c.brks = append(c.brks, breaker.NewBreaker())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.readOrder()
+	}
+}
+
+func BenchmarkReadOnly(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		readOnly("SELECT a, b FROM t WHERE id IN (1, 2, 3) AND name = 'foo' ORDER BY a LIMIT 10")
+	}
+}
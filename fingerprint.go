@@ -0,0 +1,189 @@
+package sqlbreaker
+
+import "strings"
+
+// Fingerprint returns the fingerprint of query, which is used to group queries that only differ in values.
+// Literals and placeholders are replaced with ?, lists of them are collapsed into ?+,
+// comments are removed, whitespaces are collapsed and the rest is lowercased.
+//
+//	SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'foo' -- comment
+//
+// is fingerprinted as
+//
+//	select * from t where id in (?+) and name = ?
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	space := false
+	writeSpace := func() {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"), c == '#':
+			i = skipUntil(query, i, "\n")
+			space = true
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipUntil(query, i+2, "*/")
+			space = true
+		case isSpace(c):
+			i++
+			space = true
+		case c == '\'':
+			writeSpace()
+			i = skipQuoted(query, i, c)
+			b.WriteByte('?')
+		case c == '"' || c == '`':
+			// quoted identifiers are kept as is.
+			writeSpace()
+			end := skipQuoted(query, i, c)
+			b.WriteString(query[i:end])
+			i = end
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			writeSpace()
+			i = skipDigits(query, i+1)
+			b.WriteByte('?')
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			writeSpace()
+			i = skipNumber(query, i)
+			b.WriteByte('?')
+		case isIdent(c):
+			writeSpace()
+			start := i
+			for i < len(query) && (isIdent(query[i]) || isDigit(query[i]) || query[i] == '$') {
+				i++
+			}
+			b.WriteString(strings.ToLower(query[start:i]))
+		default:
+			writeSpace()
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return collapseLists(b.String())
+}
+
+// collapseLists collapses lists of ? like "?, ?, ?" into "?+".
+func collapseLists(s string) string {
+	if !strings.Contains(s, "?") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		b.WriteByte(s[i])
+		if s[i] != '?' {
+			continue
+		}
+
+		end, collapsed := i+1, false
+		for {
+			j := end
+			for j < len(s) && s[j] == ' ' {
+				j++
+			}
+			if j >= len(s) || s[j] != ',' {
+				break
+			}
+			j++
+			for j < len(s) && s[j] == ' ' {
+				j++
+			}
+			if j >= len(s) || s[j] != '?' {
+				break
+			}
+			end, collapsed = j+1, true
+		}
+
+		if collapsed {
+			b.WriteByte('+')
+			i = end - 1
+		}
+	}
+
+	return b.String()
+}
+
+func skipUntil(s string, i int, end string) int {
+	if n := strings.Index(s[i:], end); n >= 0 {
+		return i + n + len(end)
+	}
+
+	return len(s)
+}
+
+// skipQuoted returns the index after the closing quote of the quoted string started at i.
+func skipQuoted(s string, i int, quote byte) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			// doubled quote is an escaped quote.
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+
+	return len(s)
+}
+
+func skipNumber(s string, i int) int {
+	if strings.HasPrefix(s[i:], "0x") || strings.HasPrefix(s[i:], "0X") {
+		i += 2
+		for i < len(s) && isHex(s[i]) {
+			i++
+		}
+		return i
+	}
+
+	for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+		i++
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			i = skipDigits(s, j)
+		}
+	}
+
+	return i
+}
+
+func skipDigits(s string, i int) int {
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c >= 0x80 || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
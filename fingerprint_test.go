@@ -0,0 +1,64 @@
+package sqlbreaker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		query  string
+		expect string
+	}{
+		{
+			query:  "",
+			expect: "",
+		},
+		{
+			query:  "SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'foo' -- comment",
+			expect: "select * from t where id in (?+) and name = ?",
+		},
+		{
+			query:  "  select\n\tage+1 as age,name from t where age = ?;",
+			expect: "select age+? as age,name from t where age = ?;",
+		},
+		{
+			query:  "insert into t values (?,?),(?, ?)",
+			expect: "insert into t values (?+),(?+)",
+		},
+		{
+			query:  "select * from t1 where a = $1 and b = $2",
+			expect: "select * from t1 where a = ? and b = ?",
+		},
+		{
+			query:  `select "Name", ` + "`Age`" + ` from /* comment */ t where name = 'it''s' or name = 'a\'b'`,
+			expect: `select "Name", ` + "`Age`" + ` from t where name = ? or name = ?`,
+		},
+		{
+			query:  "select 1.5e-3, .5, 0xFF, -2 # comment\nfrom dual",
+			expect: "select ?+, -? from dual",
+		},
+		{
+			query:  "select col1, t2.col_2 from t2",
+			expect: "select col1, t2.col_2 from t2",
+		},
+		{
+			query:  "select 'unterminated",
+			expect: "select ?",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			assert.Equal(t, test.expect, Fingerprint(test.query))
+		})
+	}
+}
+
+func BenchmarkFingerprint(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Fingerprint("SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'foo' -- comment")
+	}
+}
@@ -5,22 +5,198 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/chenquan/sqlbreaker/metrics"
 	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
 	"github.com/chenquan/sqlplus"
 )
 
+// The kinds of operations guarded by the Hook.
+const (
+	OpExec    = "exec"
+	OpQuery   = "query"
+	OpPrepare = "prepare"
+	OpBegin   = "begin"
+)
+
+// The intervals to retry the admission of a waiting call, they double from the min to the max.
+const (
+	minRetryInterval = time.Millisecond * 5
+	maxRetryInterval = time.Millisecond * 100
+)
+
+// maxCachedQueries caps the queries whose slow thresholds are cached,
+// as the queries with inlined values are countless.
+const maxCachedQueries = 1024
+
 var _ sqlplus.Hook = (*Hook)(nil)
 
+// ErrWaitTimeout is matched by the errors returned when a waiting call is not admitted in time, see WaitTimeoutError.
+var ErrWaitTimeout = errors.New("breaker admission timed out")
+
 type (
 	Hook struct {
-		brk breaker.Breaker
+		brk        breaker.Breaker
+		metrics    metrics.Metrics
+		acceptable breaker.Acceptable
+		slow       slowThresholds
+		dryRun     bool
+		key        KeyFunc
+		maxWait    time.Duration
+	}
+
+	// A WaitTimeoutError is returned when a call waits for admission but is not admitted in time,
+	// errors.Is(err, ErrWaitTimeout) reports true for it, and it unwraps to the last error of the breaker.
+	WaitTimeoutError struct {
+		// Waited is how long the call waited.
+		Waited time.Duration
+		// Err is the last error of the breaker.
+		Err error
+	}
+
+	// KeyFunc returns the key of the call of the operation kind op, see WithKeyFunc.
+	KeyFunc func(ctx context.Context, op string) string
+
+	// slowThresholds are the latency thresholds above which accepted calls are counted as failures.
+	slowThresholds struct {
+		all time.Duration
+		// keyed by operation kind.
+		ops map[string]time.Duration
+		// keyed by query fingerprint.
+		queries map[string]time.Duration
+		// cached holds the queryThreshold of each query, which saves fingerprinting the query on every call.
+		cached *queryCache
+	}
+
+	queryCache struct {
+		sync.Map
+		size int64
+	}
+
+	// queryThreshold is the slow threshold of a query, ok is false if there is none for its fingerprint.
+	queryThreshold struct {
+		threshold time.Duration
+		ok        bool
 	}
 	allowKey struct{}
+
+	// allowedCtx carries an allowed call, it's the value of allowKey in itself.
+	// It saves the allocations of context.WithValue and of boxing the value on every call.
+	// promise is nil if the call would have been dropped in dry run.
+	allowedCtx struct {
+		context.Context
+		promise breaker.Promise
+		start   time.Duration
+	}
+
+	// droppedCtx carries a dropped call, it hides the allowed call of its parent,
+	// so that the hooks after the call don't resolve the promise of the parent.
+	droppedCtx struct {
+		context.Context
+	}
+
+	// nestedCtx carries a call nested in an allowed call, e.g. the prepare and exec that sqlplus runs
+	// for an exec if the driver can't exec directly. The nested calls are neither admitted nor resolved,
+	// as the allowed call holds the admission for them. It's the value of allowKey in itself.
+	nestedCtx struct {
+		context.Context
+	}
+
+	// HookOption defines the method to customize a Hook.
+	HookOption func(h *Hook)
 )
 
-func NewBreakerHook(brk breaker.Breaker) *Hook {
-	return &Hook{brk: brk}
+func NewBreakerHook(brk breaker.Breaker, opts ...HookOption) *Hook {
+	h := &Hook{brk: brk}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// WithMetrics returns a HookOption to record the events of the calls to m.
+func WithMetrics(m metrics.Metrics) HookOption {
+	return func(h *Hook) {
+		h.metrics = m
+	}
+}
+
+// WithAcceptable returns a HookOption to customize which errors are not counted as failures.
+// By default, only sql.ErrNoRows is acceptable, include driver.ErrSkip or context.Canceled in acceptable
+// if they tell nothing about the health of the database, but mind that the cancellations by the clients
+// tired of a slow database are a sign of overload.
+func WithAcceptable(acceptable breaker.Acceptable) HookOption {
+	return func(h *Hook) {
+		h.acceptable = acceptable
+	}
+}
+
+// WithSlowThreshold returns a HookOption to count the calls that succeed, or fail with acceptable errors,
+// but take longer than threshold as failures.
+func WithSlowThreshold(threshold time.Duration) HookOption {
+	return func(h *Hook) {
+		h.slow.all = threshold
+	}
+}
+
+// WithOpSlowThreshold is like WithSlowThreshold but only for the operation kind op, e.g. OpQuery.
+// It takes precedence over WithSlowThreshold.
+func WithOpSlowThreshold(op string, threshold time.Duration) HookOption {
+	return func(h *Hook) {
+		if h.slow.ops == nil {
+			h.slow.ops = make(map[string]time.Duration)
+		}
+		h.slow.ops[op] = threshold
+	}
+}
+
+// WithQuerySlowThreshold is like WithSlowThreshold but only for the queries that have the same Fingerprint as query.
+// It takes precedence over WithOpSlowThreshold and WithSlowThreshold.
+func WithQuerySlowThreshold(query string, threshold time.Duration) HookOption {
+	return func(h *Hook) {
+		if h.slow.queries == nil {
+			h.slow.queries = make(map[string]time.Duration)
+			h.slow.cached = new(queryCache)
+		}
+		h.slow.queries[Fingerprint(query)] = threshold
+	}
+}
+
+// WithDryRun returns a HookOption to let the calls that the breaker drops through,
+// they are counted as metrics.WouldDrop events, and their outcomes are not reported to the breaker,
+// as if they were dropped. It helps to tune the breaker against real traffic before enforcing it.
+func WithDryRun() HookOption {
+	return func(h *Hook) {
+		h.dryRun = true
+	}
+}
+
+// WithKeyFunc returns a HookOption to allow the calls by the key that key returns,
+// if the breaker is a breaker.KeyedBreaker, so that the limits set for the key take effect,
+// e.g. breaker.WithKeyRateLimit. An empty key means the call has no key.
+func WithKeyFunc(key KeyFunc) HookOption {
+	return func(h *Hook) {
+		h.key = key
+	}
+}
+
+// WithMaxWait returns a HookOption to make the calls that the breaker drops wait up to maxWait,
+// or until the deadline of the context if sooner, retrying the admission meanwhile.
+// The calls that are not admitted in time fail with a *WaitTimeoutError,
+// the wait times are observed if the Metrics is a metrics.WaitObserver,
+// and recorded in the breaker if it's a breaker.WaitRecorder, see breaker.Stats.WaitTime.
+// The waits inside a breaker.BoundedBreaker, e.g. for a bulkhead slot, are capped at the time left.
+// It suits batch jobs that would rather slow down than fail.
+func WithMaxWait(maxWait time.Duration) HookOption {
+	return func(h *Hook) {
+		h.maxWait = maxWait
+	}
 }
 
 func (h *Hook) BeforeClose(ctx context.Context, err error) (context.Context, error) {
@@ -40,49 +216,49 @@ func (h *Hook) AfterConnect(ctx context.Context, dc driver.Conn, err error) (con
 }
 
 func (h *Hook) BeforeExecContext(ctx context.Context, query string, args []driver.NamedValue, _ error) (context.Context, string, []driver.NamedValue, error) {
-	ctx, err := h.allow(ctx)
+	ctx, err := h.allow(ctx, OpExec)
 
 	return ctx, query, args, err
 }
 
-func (h *Hook) AfterExecContext(ctx context.Context, _ string, _ []driver.NamedValue, dr driver.Result, err error) (context.Context, driver.Result, error) {
-	h.handleAllow(ctx, err)
+func (h *Hook) AfterExecContext(ctx context.Context, query string, _ []driver.NamedValue, dr driver.Result, err error) (context.Context, driver.Result, error) {
+	h.handleAllow(ctx, OpExec, query, err)
 
 	return ctx, dr, err
 }
 
 func (h *Hook) BeforeBeginTx(ctx context.Context, opts driver.TxOptions, _ error) (context.Context, driver.TxOptions, error) {
-	ctx, err := h.allow(ctx)
+	ctx, err := h.allow(ctx, OpBegin)
 
 	return ctx, opts, err
 }
 
 func (h *Hook) AfterBeginTx(ctx context.Context, _ driver.TxOptions, dt driver.Tx, err error) (context.Context, driver.Tx, error) {
-	h.handleAllow(ctx, err)
+	h.handleAllow(ctx, OpBegin, "", err)
 
 	return ctx, dt, err
 }
 
 func (h *Hook) BeforeQueryContext(ctx context.Context, query string, args []driver.NamedValue, _ error) (context.Context, string, []driver.NamedValue, error) {
-	ctx, err := h.allow(ctx)
+	ctx, err := h.allow(ctx, OpQuery)
 
 	return ctx, query, args, err
 }
 
-func (h *Hook) AfterQueryContext(ctx context.Context, _ string, _ []driver.NamedValue, rows driver.Rows, err error) (context.Context, driver.Rows, error) {
-	h.handleAllow(ctx, err)
+func (h *Hook) AfterQueryContext(ctx context.Context, query string, _ []driver.NamedValue, rows driver.Rows, err error) (context.Context, driver.Rows, error) {
+	h.handleAllow(ctx, OpQuery, query, err)
 
 	return ctx, rows, err
 }
 
 func (h *Hook) BeforePrepareContext(ctx context.Context, query string, _ error) (context.Context, string, error) {
-	ctx, err := h.allow(ctx)
+	ctx, err := h.allow(ctx, OpPrepare)
 
 	return ctx, query, err
 }
 
-func (h *Hook) AfterPrepareContext(ctx context.Context, _ string, ds driver.Stmt, err error) (context.Context, driver.Stmt, error) {
-	h.handleAllow(ctx, err)
+func (h *Hook) AfterPrepareContext(ctx context.Context, query string, ds driver.Stmt, err error) (context.Context, driver.Stmt, error) {
+	h.handleAllow(ctx, OpPrepare, query, err)
 
 	return ctx, ds, err
 }
@@ -104,50 +280,297 @@ func (h *Hook) AfterRollback(ctx context.Context, err error) (context.Context, e
 }
 
 func (h *Hook) BeforeStmtQueryContext(ctx context.Context, _ string, args []driver.NamedValue, _ error) (context.Context, []driver.NamedValue, error) {
-	ctx, err := h.allow(ctx)
+	ctx, err := h.allow(ctx, OpQuery)
 
 	return ctx, args, err
 }
 
-func (h *Hook) AfterStmtQueryContext(ctx context.Context, _ string, _ []driver.NamedValue, rows driver.Rows, err error) (context.Context, driver.Rows, error) {
-	h.handleAllow(ctx, err)
+func (h *Hook) AfterStmtQueryContext(ctx context.Context, query string, _ []driver.NamedValue, rows driver.Rows, err error) (context.Context, driver.Rows, error) {
+	h.handleAllow(ctx, OpQuery, query, err)
 
 	return ctx, rows, err
 }
 
 func (h *Hook) BeforeStmtExecContext(ctx context.Context, _ string, args []driver.NamedValue, _ error) (context.Context, []driver.NamedValue, error) {
-	ctx, err := h.allow(ctx)
+	ctx, err := h.allow(ctx, OpExec)
 
 	return ctx, args, err
 }
 
-func (h *Hook) AfterStmtExecContext(ctx context.Context, _ string, _ []driver.NamedValue, r driver.Result, err error) (context.Context, driver.Result, error) {
-	h.handleAllow(ctx, err)
+func (h *Hook) AfterStmtExecContext(ctx context.Context, query string, _ []driver.NamedValue, r driver.Result, err error) (context.Context, driver.Result, error) {
+	h.handleAllow(ctx, OpExec, query, err)
 
 	return ctx, r, err
 }
 
-func (h *Hook) allow(ctx context.Context) (context.Context, error) {
-	allow, err := h.brk.Allow()
-	if err != nil {
-		return ctx, err
+func (h *Hook) allow(ctx context.Context, op string) (context.Context, error) {
+	if ctx.Value(allowKey{}) != nil {
+		return &nestedCtx{Context: ctx}, nil
 	}
-	ctx = context.WithValue(ctx, allowKey{}, allow)
 
-	return ctx, err
+	start := timex.Now()
+	deadline, bounded := h.deadline(ctx, start)
+	allow, err := h.admit(ctx, op, deadline, bounded)
+	if err != nil && h.maxWait > 0 && !h.dryRun {
+		allow, err = h.wait(ctx, op, start, deadline, err)
+	}
+
+	switch {
+	case err == nil:
+		h.inc(op, metrics.Allowed)
+	case h.dryRun:
+		h.inc(op, metrics.WouldDrop)
+	default:
+		h.inc(op, metrics.Dropped)
+		return &droppedCtx{Context: ctx}, err
+	}
+
+	return &allowedCtx{
+		Context: ctx,
+		promise: allow,
+		start:   timex.Now(),
+	}, nil
+}
+
+// deadline returns the deadline of the admission of the call that starts at start,
+// which is bounded by the max wait and the deadline of ctx, false if not bounded.
+func (h *Hook) deadline(ctx context.Context, start time.Duration) (time.Duration, bool) {
+	var deadline time.Duration
+	bounded := h.maxWait > 0 && !h.dryRun
+	if bounded {
+		deadline = start + h.maxWait
+	}
+	if d, ok := ctx.Deadline(); ok {
+		if ctxDeadline := start + time.Until(d); !bounded || ctxDeadline < deadline {
+			deadline, bounded = ctxDeadline, true
+		}
+	}
+
+	return deadline, bounded
+}
+
+// admit allows the call, the waits inside the breaker are bounded by deadline if bounded.
+func (h *Hook) admit(ctx context.Context, op string, deadline time.Duration, bounded bool) (breaker.Promise, error) {
+	var key string
+	if h.key != nil {
+		if _, ok := h.brk.(breaker.KeyedBreaker); ok {
+			key = h.key(ctx, op)
+		}
+	}
+
+	if bounded {
+		if bb, ok := h.brk.(breaker.BoundedBreaker); ok {
+			return bb.AllowWithin(key, deadline-timex.Now())
+		}
+	}
+	if len(key) > 0 {
+		return h.brk.(breaker.KeyedBreaker).AllowKey(key)
+	}
+
+	return h.brk.Allow()
 }
 
-func (h *Hook) handleAllow(ctx context.Context, err error) {
-	value := ctx.Value(allowKey{})
-	if value == nil {
+// wait retries the admission of the call that started at start and was dropped with err,
+// until it's admitted or deadline is reached.
+func (h *Hook) wait(ctx context.Context, op string, start, deadline time.Duration, err error) (breaker.Promise, error) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	interval := minRetryInterval
+	for {
+		left := deadline - timex.Now()
+		if left <= 0 {
+			return nil, h.timeout(op, timex.Since(start), err)
+		}
+
+		retry := interval
+		var rateErr *breaker.RateLimitError
+		if errors.As(err, &rateErr) && rateErr.RetryAfter > 0 {
+			// the rate limiter knows when it admits a call again.
+			retry = rateErr.RetryAfter
+		} else {
+			interval *= 2
+			if interval > maxRetryInterval {
+				interval = maxRetryInterval
+			}
+		}
+		if retry > left {
+			retry = left
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(retry)
+		} else {
+			timer.Reset(retry)
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, h.timeout(op, timex.Since(start), err)
+			}
+			h.observeWait(op, timex.Since(start), false)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		var promise breaker.Promise
+		if promise, err = h.admit(ctx, op, deadline, true); err == nil {
+			h.observeWait(op, timex.Since(start), false)
+			return promise, nil
+		}
+	}
+}
+
+func (h *Hook) timeout(op string, waited time.Duration, err error) error {
+	h.observeWait(op, waited, true)
+	return &WaitTimeoutError{Waited: waited, Err: err}
+}
+
+// observeWait reports the wait to the metrics and the breaker, whichever takes it.
+func (h *Hook) observeWait(op string, wait time.Duration, timedOut bool) {
+	if wo, ok := h.metrics.(metrics.WaitObserver); ok {
+		wo.ObserveWait(h.brk, op, wait)
+	}
+	if wr, ok := h.brk.(breaker.WaitRecorder); ok {
+		wr.RecordWait(wait, timedOut)
+	}
+}
+
+func (h *Hook) handleAllow(ctx context.Context, op, query string, err error) {
+	allow, ok := ctx.Value(allowKey{}).(*allowedCtx)
+	if !ok {
+		return
+	}
+
+	latency := timex.Since(allow.start)
+	if h.metrics != nil {
+		h.metrics.Observe(h.brk, op, latency)
+	}
+	if allow.promise == nil {
+		return
+	}
+
+	if err != nil && !h.accept(err) {
+		h.inc(op, metrics.Rejected)
+		reject(allow.promise, op, err.Error(), latency)
+		return
+	}
+
+	if threshold := h.slow.threshold(op, query); threshold > 0 && latency > threshold {
+		h.inc(op, metrics.Rejected)
+		reject(allow.promise, op, fmt.Sprintf("slow call: %s exceeds %s", latency, threshold), latency)
+		return
+	}
+
+	if err == nil {
+		h.inc(op, metrics.Accepted)
+	} else {
+		h.inc(op, metrics.Ignored)
+	}
+	accept(allow.promise, op, latency)
+}
+
+func (h *Hook) accept(err error) bool {
+	if h.acceptable != nil {
+		return h.acceptable(err)
+	}
+
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+func (st slowThresholds) threshold(op, query string) time.Duration {
+	if len(st.queries) > 0 && len(query) > 0 {
+		if qt := st.query(query); qt.ok {
+			return qt.threshold
+		}
+	}
+
+	if threshold, ok := st.ops[op]; ok {
+		return threshold
+	}
+
+	return st.all
+}
+
+// query returns the threshold of the fingerprint of query, which is cached for the first maxCachedQueries queries.
+func (st slowThresholds) query(query string) queryThreshold {
+	if v, ok := st.cached.Load(query); ok {
+		return v.(queryThreshold)
+	}
+
+	var qt queryThreshold
+	qt.threshold, qt.ok = st.queries[Fingerprint(query)]
+	if atomic.LoadInt64(&st.cached.size) < maxCachedQueries {
+		if _, loaded := st.cached.LoadOrStore(query, qt); !loaded {
+			atomic.AddInt64(&st.cached.size, 1)
+		}
+	}
+
+	return qt
+}
+
+func accept(promise breaker.Promise, op string, latency time.Duration) {
+	if p, ok := promise.(breaker.LatencyPromise); ok {
+		p.AcceptWithLatency(op, latency)
 		return
 	}
 
-	allow := value.(breaker.Promise)
-	if err == nil || errors.Is(err, sql.ErrNoRows) {
-		allow.Accept()
+	promise.Accept()
+}
+
+func reject(promise breaker.Promise, op, reason string, latency time.Duration) {
+	if p, ok := promise.(breaker.LatencyPromise); ok {
+		p.RejectWithLatency(op, reason, latency)
 		return
 	}
 
-	allow.Reject(err.Error())
+	promise.Reject(reason)
+}
+
+func (e *WaitTimeoutError) Error() string {
+	return fmt.Sprintf("%s after %s: %s", ErrWaitTimeout, e.Waited, e.Err)
+}
+
+// Is reports whether target is ErrWaitTimeout.
+func (e *WaitTimeoutError) Is(target error) bool {
+	return target == ErrWaitTimeout
+}
+
+// Unwrap returns the last error of the breaker.
+func (e *WaitTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (c *allowedCtx) Value(key interface{}) interface{} {
+	if key == (allowKey{}) {
+		return c
+	}
+
+	return c.Context.Value(key)
+}
+
+func (c *droppedCtx) Value(key interface{}) interface{} {
+	if key == (allowKey{}) {
+		return nil
+	}
+
+	return c.Context.Value(key)
+}
+
+func (c *nestedCtx) Value(key interface{}) interface{} {
+	if key == (allowKey{}) {
+		return c
+	}
+
+	return c.Context.Value(key)
+}
+
+func (h *Hook) inc(op string, event metrics.Event) {
+	if h.metrics != nil {
+		h.metrics.Inc(h.brk, op, event)
+	}
 }
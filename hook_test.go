@@ -5,8 +5,11 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/chenquan/sqlbreaker/metrics"
 	"github.com/chenquan/sqlbreaker/pkg/breaker"
 	"github.com/stretchr/testify/assert"
 )
@@ -119,6 +122,181 @@ func TestHook_StmtQueryContext(t *testing.T) {
 	})
 }
 
+func TestHook_Metrics(t *testing.T) {
+	c := metrics.NewCollector()
+	b := breaker.NewBreaker(breaker.WithName("metrics"))
+	breakerHook := NewBreakerHook(b, WithMetrics(c))
+
+	ctx, _, _, err := breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	_, _, _ = breakerHook.AfterExecContext(ctx, "", nil, nil, nil)
+
+	ctx, _, err = breakerHook.BeforeStmtExecContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	_, _, _ = breakerHook.AfterStmtExecContext(ctx, "", nil, nil, sql.ErrNoRows)
+
+	ctx, _, _, err = breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	_, _, _ = breakerHook.AfterQueryContext(ctx, "", nil, nil, errors.New("any"))
+
+	b.(breaker.Controller).Force(breaker.StateForcedOpen)
+	ctx, _, err = breakerHook.BeforePrepareContext(context.Background(), "", nil)
+	assert.ErrorIs(t, err, breaker.ErrServiceUnavailable)
+	_, _, _ = breakerHook.AfterPrepareContext(ctx, "", nil, err)
+
+	snapshot := c.Snapshot()["metrics"]
+	assert.Equal(t, map[metrics.Event]int64{metrics.Allowed: 2, metrics.Accepted: 1, metrics.Ignored: 1}, snapshot.Ops[OpExec].Events)
+	assert.Equal(t, int64(2), snapshot.Ops[OpExec].Latency.Count)
+	assert.Equal(t, map[metrics.Event]int64{metrics.Allowed: 1, metrics.Rejected: 1}, snapshot.Ops[OpQuery].Events)
+	assert.Equal(t, int64(1), snapshot.Ops[OpQuery].Latency.Count)
+	assert.Equal(t, map[metrics.Event]int64{metrics.Dropped: 1}, snapshot.Ops[OpPrepare].Events)
+	assert.Equal(t, int64(0), snapshot.Ops[OpPrepare].Latency.Count)
+}
+
+func TestHook_Latency(t *testing.T) {
+	b := breaker.NewBreaker()
+	breakerHook := NewBreakerHook(b)
+
+	ctx, _, _, err := breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 10)
+	_, _, _ = breakerHook.AfterQueryContext(ctx, "", nil, nil, nil)
+
+	ctx, _, err = breakerHook.BeforeBeginTx(context.Background(), driver.TxOptions{}, nil)
+	assert.NoError(t, err)
+	_, _, _ = breakerHook.AfterBeginTx(ctx, driver.TxOptions{}, nil, errors.New("any"))
+
+	stats := b.(breaker.Inspector).Stats()
+	assert.Equal(t, int64(2), stats.Latency.Count)
+	assert.Equal(t, int64(1), stats.OpLatency[OpQuery].Count)
+	assert.True(t, stats.OpLatency[OpQuery].Max >= time.Millisecond*10)
+	assert.Equal(t, int64(1), stats.OpLatency[OpBegin].Count)
+}
+
+func TestHook_Acceptable(t *testing.T) {
+	errAcceptable := errors.New("acceptable")
+	b := breaker.NewBreaker()
+	breakerHook := NewBreakerHook(b, WithAcceptable(func(err error) bool {
+		return errors.Is(err, errAcceptable)
+	}))
+
+	for i := 0; i < 1000; i++ {
+		ctx, _, _, err := breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+		assert.NoError(t, err)
+		_, _, err = breakerHook.AfterExecContext(ctx, "", nil, nil, errAcceptable)
+		assert.ErrorIs(t, err, errAcceptable)
+	}
+
+	stats := b.(breaker.Inspector).Stats()
+	assert.Equal(t, stats.Total, stats.Accepts)
+}
+
+func TestHook_AcceptableDefault(t *testing.T) {
+	b := breaker.NewBreaker()
+	breakerHook := NewBreakerHook(b)
+	for _, err := range []error{sql.ErrNoRows, driver.ErrSkip, context.Canceled} {
+		ctx, _, _, allowErr := breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+		assert.NoError(t, allowErr)
+		_, _, _ = breakerHook.AfterExecContext(ctx, "", nil, nil, err)
+	}
+
+	// only sql.ErrNoRows is acceptable by default.
+	stats := b.(breaker.Inspector).Stats()
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, int64(1), stats.Accepts)
+}
+
+func TestHook_SlowThreshold(t *testing.T) {
+	const slowQuery = "select * from t where id = 1"
+	b := breaker.NewBreaker()
+	breakerHook := NewBreakerHook(b,
+		WithSlowThreshold(time.Nanosecond),
+		WithOpSlowThreshold(OpQuery, time.Hour),
+		WithQuerySlowThreshold(slowQuery, time.Nanosecond),
+	)
+
+	tests := []struct {
+		name   string
+		query  string
+		call   func(ctx context.Context, query string) error
+		accept bool
+	}{
+		{
+			name:  "global",
+			query: "update t set a = 1",
+			call: func(ctx context.Context, query string) error {
+				ctx, _, _, err := breakerHook.BeforeExecContext(ctx, query, nil, nil)
+				assert.NoError(t, err)
+				_, _, err = breakerHook.AfterExecContext(ctx, query, nil, nil, nil)
+				return err
+			},
+		},
+		{
+			name:   "op",
+			query:  "select * from t",
+			accept: true,
+			call: func(ctx context.Context, query string) error {
+				ctx, _, _, err := breakerHook.BeforeQueryContext(ctx, query, nil, nil)
+				assert.NoError(t, err)
+				_, _, err = breakerHook.AfterQueryContext(ctx, query, nil, nil, nil)
+				return err
+			},
+		},
+		{
+			name:  "query",
+			query: "SELECT * FROM t WHERE id = 2",
+			call: func(ctx context.Context, query string) error {
+				ctx, _, err := breakerHook.BeforeStmtQueryContext(ctx, query, nil, nil)
+				assert.NoError(t, err)
+				_, _, err = breakerHook.AfterStmtQueryContext(ctx, query, nil, nil, nil)
+				return err
+			},
+		},
+		{
+			name:  "acceptable",
+			query: slowQuery,
+			call: func(ctx context.Context, query string) error {
+				ctx, _, _, err := breakerHook.BeforeQueryContext(ctx, query, nil, nil)
+				assert.NoError(t, err)
+				_, _, err = breakerHook.AfterQueryContext(ctx, query, nil, nil, sql.ErrNoRows)
+				assert.ErrorIs(t, err, sql.ErrNoRows)
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b.(breaker.Controller).Reset()
+			assert.NoError(t, test.call(context.Background(), test.query))
+
+			stats := b.(breaker.Inspector).Stats()
+			assert.Equal(t, int64(1), stats.Total)
+			if test.accept {
+				assert.Equal(t, int64(1), stats.Accepts)
+				assert.Empty(t, stats.Reasons)
+			} else {
+				assert.Equal(t, int64(0), stats.Accepts)
+				assert.Len(t, stats.Reasons, 1)
+				assert.Contains(t, stats.Reasons[0], "slow call")
+			}
+		})
+	}
+}
+
+func TestSlowThresholds_Cached(t *testing.T) {
+	h := NewBreakerHook(breaker.NewBreaker(), WithQuerySlowThreshold("select * from t where id = 1", time.Second))
+	assert.Equal(t, time.Second, h.slow.threshold(OpQuery, "SELECT * FROM t WHERE id = 2"))
+	assert.Equal(t, time.Second, h.slow.threshold(OpQuery, "SELECT * FROM t WHERE id = 2"))
+	assert.Equal(t, time.Duration(0), h.slow.threshold(OpQuery, "select 1"))
+	assert.Equal(t, int64(2), h.slow.cached.size)
+
+	for i := 0; i < maxCachedQueries*2; i++ {
+		assert.Equal(t, time.Second, h.slow.threshold(OpQuery, fmt.Sprintf("select * from t where id = %d", i)))
+	}
+	assert.Equal(t, int64(maxCachedQueries), h.slow.cached.size)
+}
+
 func checkWithContext(t *testing.T, before func(ctx context.Context) (context.Context, error), after func(ctx context.Context, err error) (context.Context, error)) {
 	t.Run("allow", func(t *testing.T) {
 		for i := 0; i < 100; i++ {
@@ -172,3 +350,233 @@ func check(t *testing.T, before, after func(ctx context.Context) (context.Contex
 	assert.True(t, ctx == context.Background())
 	assert.NoError(t, err)
 }
+
+func TestHook_DryRun(t *testing.T) {
+	b := breaker.NewBreaker()
+	b.(breaker.Controller).Force(breaker.StateForcedOpen)
+	collector := metrics.NewCollector()
+	breakerHook := NewBreakerHook(b, WithDryRun(), WithMetrics(collector))
+
+	ctx, _, _, err := breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	_, _, err = breakerHook.AfterQueryContext(ctx, "", nil, nil, errors.New("any"))
+	assert.Error(t, err)
+
+	snapshot := collector.Snapshot()[b.Name()].Ops[OpQuery]
+	assert.Equal(t, map[metrics.Event]int64{metrics.WouldDrop: 1}, snapshot.Events)
+	assert.Equal(t, int64(1), snapshot.Latency.Count)
+	// the outcome is not reported to the breaker.
+	stats := b.(breaker.Inspector).Stats()
+	assert.Equal(t, int64(0), stats.Total)
+	assert.Empty(t, stats.Reasons)
+}
+
+func TestHook_Bulkhead(t *testing.T) {
+	b := breaker.NewBulkhead(1, 0)
+	breakerHook := NewBreakerHook(b)
+
+	ctx1, _, _, err := breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	ctx2, _, _, err := breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+	assert.ErrorIs(t, err, breaker.ErrBulkheadFull)
+	_, _, _ = breakerHook.AfterExecContext(ctx2, "", nil, nil, err)
+	assert.Equal(t, int64(1), b.(breaker.Inspector).Stats().InFlight)
+
+	_, _, _ = breakerHook.AfterQueryContext(ctx1, "", nil, nil, nil)
+	assert.Equal(t, int64(0), b.(breaker.Inspector).Stats().InFlight)
+	ctx2, _, _, err = breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	_, _, _ = breakerHook.AfterExecContext(ctx2, "", nil, nil, nil)
+}
+
+func TestHook_RateLimit(t *testing.T) {
+	b := breaker.NewRateLimiter(1000, 2, breaker.WithKeyRateLimit(OpExec, 1, 1))
+	breakerHook := NewBreakerHook(b, WithKeyFunc(func(_ context.Context, op string) string {
+		return op
+	}))
+
+	ctx, _, _, err := breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	_, _, _ = breakerHook.AfterExecContext(ctx, "", nil, nil, nil)
+	_, _, _, err = breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+	assert.ErrorIs(t, err, breaker.ErrRateLimited)
+	var rateErr *breaker.RateLimitError
+	assert.True(t, errors.As(err, &rateErr))
+	assert.Equal(t, OpExec, rateErr.Key)
+
+	// the queries are limited by the limit of all calls only.
+	ctx, _, _, err = breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+	assert.NoError(t, err)
+	_, _, _ = breakerHook.AfterQueryContext(ctx, "", nil, nil, nil)
+	_, _, _, err = breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+	assert.ErrorIs(t, err, breaker.ErrRateLimited)
+}
+
+func TestHook_MaxWait(t *testing.T) {
+	t.Run("admitted", func(t *testing.T) {
+		b := breaker.NewBulkhead(1, 0)
+		collector := metrics.NewCollector()
+		breakerHook := NewBreakerHook(b, WithMaxWait(time.Second), WithMetrics(collector))
+
+		ctx1, _, _, err := breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+		assert.NoError(t, err)
+		go func() {
+			time.Sleep(time.Millisecond * 20)
+			_, _, _ = breakerHook.AfterQueryContext(ctx1, "", nil, nil, nil)
+		}()
+
+		ctx2, _, _, err := breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+		assert.NoError(t, err)
+		_, _, _ = breakerHook.AfterExecContext(ctx2, "", nil, nil, nil)
+
+		snapshot := collector.Snapshot()[b.Name()].Ops[OpExec]
+		assert.Equal(t, int64(1), snapshot.Wait.Count)
+		assert.True(t, snapshot.Wait.Sum >= 0.02)
+		assert.Equal(t, map[metrics.Event]int64{metrics.Allowed: 1, metrics.Accepted: 1}, snapshot.Events)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		b := breaker.NewBulkhead(1, 0)
+		breakerHook := NewBreakerHook(b, WithMaxWait(time.Millisecond*20))
+		_, _, _, err := breakerHook.BeforeQueryContext(context.Background(), "", nil, nil)
+		assert.NoError(t, err)
+
+		_, _, _, err = breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+		assert.ErrorIs(t, err, ErrWaitTimeout)
+		assert.ErrorIs(t, err, breaker.ErrBulkheadFull)
+		var waitErr *WaitTimeoutError
+		assert.True(t, errors.As(err, &waitErr))
+		assert.True(t, waitErr.Waited >= time.Millisecond*20)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		b := breaker.NewBreaker()
+		b.(breaker.Controller).Force(breaker.StateForcedOpen)
+		breakerHook := NewBreakerHook(b, WithMaxWait(time.Minute))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		defer cancel()
+
+		start := time.Now()
+		_, _, _, err := breakerHook.BeforeExecContext(ctx, "", nil, nil)
+		assert.ErrorIs(t, err, ErrWaitTimeout)
+		assert.ErrorIs(t, err, breaker.ErrServiceUnavailable)
+		assert.True(t, time.Since(start) < time.Second)
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		b := breaker.NewBulkhead(1, time.Hour)
+		p, err := b.Allow()
+		assert.NoError(t, err)
+		defer p.Accept()
+
+		// the bulkhead doesn't hold the call past the max wait of the hook.
+		breakerHook := NewBreakerHook(b, WithMaxWait(time.Millisecond*50))
+		start := time.Now()
+		_, _, _, err = breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+		assert.ErrorIs(t, err, ErrWaitTimeout)
+		assert.ErrorIs(t, err, breaker.ErrBulkheadFull)
+		assert.True(t, time.Since(start) < time.Second)
+
+		stats := b.(breaker.Inspector).Stats()
+		assert.Equal(t, int64(1), stats.WaitTime.Count)
+		assert.Equal(t, int64(1), stats.WaitTimeouts)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		b := breaker.NewBreaker()
+		b.(breaker.Controller).Force(breaker.StateForcedOpen)
+		breakerHook := NewBreakerHook(b, WithMaxWait(time.Minute))
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*20, cancel)
+
+		_, _, _, err := breakerHook.BeforeExecContext(ctx, "", nil, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		b := breaker.NewRateLimiter(20, 1)
+		breakerHook := NewBreakerHook(b, WithMaxWait(time.Second))
+		for i := 0; i < 3; i++ {
+			ctx, _, _, err := breakerHook.BeforeExecContext(context.Background(), "", nil, nil)
+			assert.NoError(t, err)
+			_, _, _ = breakerHook.AfterExecContext(ctx, "", nil, nil, nil)
+		}
+	})
+}
+
+func TestHook_Allocs(t *testing.T) {
+	for _, pair := range hookPairs(NewBreakerHook(breaker.NewBreaker(), WithMetrics(metrics.NewCollector()))) {
+		allocs := testing.AllocsPerRun(100, func() {
+			pair.call(context.Background())
+		})
+		// only the context of the allowed call is allocated.
+		assert.True(t, allocs <= 1, "%s: %v allocs", pair.name, allocs)
+	}
+}
+
+func BenchmarkHook(b *testing.B) {
+	for _, pair := range hookPairs(NewBreakerHook(breaker.NewBreaker())) {
+		b.Run(pair.name, func(b *testing.B) {
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pair.call(ctx)
+			}
+		})
+	}
+}
+
+type hookPair struct {
+	name string
+	call func(ctx context.Context)
+}
+
+// hookPairs returns the Before/After pairs of h that are called around each kind of call.
+func hookPairs(h *Hook) []hookPair {
+	const query = "select * from t where id = ?"
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(1)}}
+
+	return []hookPair{
+		{name: "Exec", call: func(ctx context.Context) {
+			ctx, _, _, err := h.BeforeExecContext(ctx, query, args, nil)
+			_, _, _ = h.AfterExecContext(ctx, query, args, nil, err)
+		}},
+		{name: "Query", call: func(ctx context.Context) {
+			ctx, _, _, err := h.BeforeQueryContext(ctx, query, args, nil)
+			_, _, _ = h.AfterQueryContext(ctx, query, args, nil, err)
+		}},
+		{name: "Prepare", call: func(ctx context.Context) {
+			ctx, _, err := h.BeforePrepareContext(ctx, query, nil)
+			_, _, _ = h.AfterPrepareContext(ctx, query, nil, err)
+		}},
+		{name: "BeginTx", call: func(ctx context.Context) {
+			ctx, _, err := h.BeforeBeginTx(ctx, driver.TxOptions{}, nil)
+			_, _, _ = h.AfterBeginTx(ctx, driver.TxOptions{}, nil, err)
+		}},
+		{name: "StmtExec", call: func(ctx context.Context) {
+			ctx, _, err := h.BeforeStmtExecContext(ctx, query, args, nil)
+			_, _, _ = h.AfterStmtExecContext(ctx, query, args, nil, err)
+		}},
+		{name: "StmtQuery", call: func(ctx context.Context) {
+			ctx, _, err := h.BeforeStmtQueryContext(ctx, query, args, nil)
+			_, _, _ = h.AfterStmtQueryContext(ctx, query, args, nil, err)
+		}},
+		{name: "Commit", call: func(ctx context.Context) {
+			ctx, err := h.BeforeCommit(ctx, nil)
+			_, _ = h.AfterCommit(ctx, err)
+		}},
+		{name: "Rollback", call: func(ctx context.Context) {
+			ctx, err := h.BeforeRollback(ctx, nil)
+			_, _ = h.AfterRollback(ctx, err)
+		}},
+		{name: "Connect", call: func(ctx context.Context) {
+			ctx, err := h.BeforeConnect(ctx, nil)
+			_, _, _ = h.AfterConnect(ctx, nil, err)
+		}},
+		{name: "Close", call: func(ctx context.Context) {
+			ctx, err := h.BeforeClose(ctx, nil)
+			_, _ = h.AfterClose(ctx, err)
+		}},
+	}
+}
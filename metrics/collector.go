@@ -0,0 +1,388 @@
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+)
+
+const (
+	namespace = "sqlbreaker"
+
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	contentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	_ WaitObserver = (*Collector)(nil)
+
+	// latencyBuckets are the upper bounds in seconds of the latency histogram buckets.
+	latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+type (
+	// Collector is a Metrics that keeps the counters in memory.
+	// It publishes them via expvar and renders them in the OpenMetrics/Prometheus text format as an http.Handler.
+	Collector struct {
+		lock       sync.RWMutex
+		counters   map[counterKey]*int64
+		histograms map[opKey]*histogram
+		waits      map[opKey]*histogram
+		breakers   map[string]breaker.Breaker
+	}
+
+	// BreakerSnapshot is the snapshot of the metrics of a breaker.
+	BreakerSnapshot struct {
+		DropRatio float64               `json:"dropRatio"`
+		Ops       map[string]OpSnapshot `json:"ops,omitempty"`
+	}
+
+	// OpSnapshot is the snapshot of the metrics of an operation kind.
+	OpSnapshot struct {
+		Events  map[Event]int64 `json:"events,omitempty"`
+		Latency LatencySnapshot `json:"latency"`
+		// Wait is the histogram of the times the calls waited for admission.
+		Wait LatencySnapshot `json:"wait"`
+	}
+
+	// LatencySnapshot is the snapshot of a latency histogram.
+	LatencySnapshot struct {
+		Count int64 `json:"count"`
+		// Sum is the sum of the latencies in seconds.
+		Sum float64 `json:"sum"`
+		// Buckets are the cumulative counts keyed by the upper bounds in seconds.
+		Buckets map[string]int64 `json:"buckets,omitempty"`
+	}
+
+	opKey struct {
+		name string
+		op   string
+	}
+
+	counterKey struct {
+		opKey
+		event Event
+	}
+
+	sample struct {
+		counterKey
+		value int64
+	}
+
+	histogram struct {
+		// counts of each bucket, the last one is +Inf.
+		counts []int64
+		sum    int64 // in nanoseconds
+	}
+
+	histogramSample struct {
+		opKey
+		snapshot LatencySnapshot
+		// cumulative counts in the order of latencyBuckets, and +Inf at last.
+		cumulative []int64
+	}
+)
+
+// NewCollector returns a Collector.
+func NewCollector() *Collector {
+	return &Collector{
+		counters:   make(map[counterKey]*int64),
+		histograms: make(map[opKey]*histogram),
+		waits:      make(map[opKey]*histogram),
+		breakers:   make(map[string]breaker.Breaker),
+	}
+}
+
+// Inc increments the counter of event for the breaker and the operation kind op.
+func (c *Collector) Inc(brk breaker.Breaker, op string, event Event) {
+	key := counterKey{opKey: opKey{name: brk.Name(), op: op}, event: event}
+
+	c.lock.RLock()
+	counter, ok := c.counters[key]
+	c.lock.RUnlock()
+
+	if !ok {
+		c.lock.Lock()
+		if counter, ok = c.counters[key]; !ok {
+			counter = new(int64)
+			c.counters[key] = counter
+			c.breakers[key.name] = brk
+		}
+		c.lock.Unlock()
+	}
+
+	atomic.AddInt64(counter, 1)
+}
+
+// Observe records the latency of an allowed call for the breaker and the operation kind op.
+func (c *Collector) Observe(brk breaker.Breaker, op string, latency time.Duration) {
+	c.histogram(c.histograms, brk, op).observe(latency)
+}
+
+// ObserveWait records the wait time of a call for the breaker and the operation kind op.
+func (c *Collector) ObserveWait(brk breaker.Breaker, op string, wait time.Duration) {
+	c.histogram(c.waits, brk, op).observe(wait)
+}
+
+// histogram returns the histogram of the breaker and op in histograms, which is guarded by c.lock.
+func (c *Collector) histogram(histograms map[opKey]*histogram, brk breaker.Breaker, op string) *histogram {
+	key := opKey{name: brk.Name(), op: op}
+
+	c.lock.RLock()
+	h, ok := histograms[key]
+	c.lock.RUnlock()
+
+	if !ok {
+		c.lock.Lock()
+		if h, ok = histograms[key]; !ok {
+			h = &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+			histograms[key] = h
+			c.breakers[key.name] = brk
+		}
+		c.lock.Unlock()
+	}
+
+	return h
+}
+
+// Publish publishes the metrics as an expvar.Var with name.
+// Like expvar.Publish, it panics if name is already registered.
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Snapshot()
+	}))
+}
+
+// Snapshot returns the metrics keyed by the names of breakers.
+func (c *Collector) Snapshot() map[string]BreakerSnapshot {
+	snapshot := make(map[string]BreakerSnapshot)
+	for name, ratio := range c.dropRatios() {
+		snapshot[name] = BreakerSnapshot{DropRatio: ratio}
+	}
+
+	op := func(key opKey) OpSnapshot {
+		b := snapshot[key.name]
+		if b.Ops == nil {
+			b.Ops = make(map[string]OpSnapshot)
+			snapshot[key.name] = b
+		}
+
+		return b.Ops[key.op]
+	}
+
+	for _, s := range c.samples() {
+		o := op(s.opKey)
+		if o.Events == nil {
+			o.Events = make(map[Event]int64, len(events))
+		}
+		o.Events[s.event] = s.value
+		snapshot[s.name].Ops[s.op] = o
+	}
+
+	for _, s := range c.histogramSamples(c.histograms) {
+		o := op(s.opKey)
+		o.Latency = s.snapshot
+		snapshot[s.name].Ops[s.op] = o
+	}
+
+	for _, s := range c.histogramSamples(c.waits) {
+		o := op(s.opKey)
+		o.Wait = s.snapshot
+		snapshot[s.name].Ops[s.op] = o
+	}
+
+	return snapshot
+}
+
+// ServeHTTP renders the metrics in the OpenMetrics text format if the client accepts it,
+// otherwise in the Prometheus text format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", contentTypePrometheus)
+	}
+
+	_ = c.write(w, openMetrics)
+}
+
+// WriteOpenMetrics writes the metrics in the OpenMetrics text format to w.
+func (c *Collector) WriteOpenMetrics(w io.Writer) error {
+	return c.write(w, true)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format to w.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	return c.write(w, false)
+}
+
+func (c *Collector) write(w io.Writer, openMetrics bool) error {
+	bw := bufio.NewWriter(w)
+
+	callsName := namespace + "_calls"
+	if openMetrics {
+		writeHeader(bw, callsName, "counter", "The number of calls guarded by breakers.")
+	} else {
+		writeHeader(bw, callsName+"_total", "counter", "The number of calls guarded by breakers.")
+	}
+	for _, s := range c.samples() {
+		fmt.Fprintf(bw, "%s_total{breaker=\"%s\",op=\"%s\",event=\"%s\"} %d\n",
+			callsName, escape(s.name), escape(s.op), escape(string(s.event)), s.value)
+	}
+
+	ratioName := namespace + "_drop_ratio"
+	writeHeader(bw, ratioName, "gauge", "The ratio of calls that breakers are dropping.")
+	ratios := c.dropRatios()
+	names := make([]string, 0, len(ratios))
+	for name := range ratios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s{breaker=\"%s\"} %s\n", ratioName, escape(name), formatFloat(ratios[name]))
+	}
+
+	latencyName := namespace + "_call_duration_seconds"
+	writeHeader(bw, latencyName, "histogram", "The latency of calls guarded by breakers.")
+	writeHistograms(bw, latencyName, c.histogramSamples(c.histograms))
+
+	waitName := namespace + "_wait_duration_seconds"
+	writeHeader(bw, waitName, "histogram", "The time calls waited for admission by breakers.")
+	writeHistograms(bw, waitName, c.histogramSamples(c.waits))
+
+	if openMetrics {
+		fmt.Fprintln(bw, "# EOF")
+	}
+
+	return bw.Flush()
+}
+
+func (c *Collector) samples() []sample {
+	c.lock.RLock()
+	samples := make([]sample, 0, len(c.counters))
+	for key, counter := range c.counters {
+		samples = append(samples, sample{counterKey: key, value: atomic.LoadInt64(counter)})
+	}
+	c.lock.RUnlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.op != b.op {
+			return a.op < b.op
+		}
+		return a.event < b.event
+	})
+
+	return samples
+}
+
+// histogramSamples returns the samples of histograms, which is guarded by c.lock.
+func (c *Collector) histogramSamples(histograms map[opKey]*histogram) []histogramSample {
+	c.lock.RLock()
+	samples := make([]histogramSample, 0, len(histograms))
+	for key, h := range histograms {
+		samples = append(samples, h.sample(key))
+	}
+	c.lock.RUnlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return a.op < b.op
+	})
+
+	return samples
+}
+
+func (c *Collector) dropRatios() map[string]float64 {
+	c.lock.RLock()
+	breakers := make([]breaker.Breaker, 0, len(c.breakers))
+	for _, b := range c.breakers {
+		breakers = append(breakers, b)
+	}
+	c.lock.RUnlock()
+
+	ratios := make(map[string]float64, len(breakers))
+	for _, b := range breakers {
+		if i, ok := b.(breaker.Inspector); ok {
+			ratios[b.Name()] = i.Stats().DropRatio
+		}
+	}
+
+	return ratios
+}
+
+func (h *histogram) observe(latency time.Duration) {
+	v := latency.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(latency))
+}
+
+func (h *histogram) sample(key opKey) histogramSample {
+	s := histogramSample{
+		opKey:      key,
+		cumulative: make([]int64, len(h.counts)),
+	}
+
+	var cumulative int64
+	for i := range h.counts {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		s.cumulative[i] = cumulative
+	}
+
+	// the buckets are read without a lock, keep the count consistent with them.
+	s.snapshot = LatencySnapshot{
+		Count:   cumulative,
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)).Seconds(),
+		Buckets: make(map[string]int64, len(latencyBuckets)),
+	}
+	for i, bound := range latencyBuckets {
+		s.snapshot.Buckets[formatFloat(bound)] = s.cumulative[i]
+	}
+
+	return s
+}
+
+func writeHistograms(w io.Writer, name string, samples []histogramSample) {
+	for _, s := range samples {
+		labels := fmt.Sprintf("breaker=\"%s\",op=\"%s\"", escape(s.name), escape(s.op))
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), s.cumulative[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, s.snapshot.Count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(s.snapshot.Sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, s.snapshot.Count)
+	}
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
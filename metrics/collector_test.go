@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector_Inc(t *testing.T) {
+	c := NewCollector()
+	b := breaker.NewBreaker(breaker.WithName("inc"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Inc(b, "exec", Allowed)
+				c.Inc(b, "query", Dropped)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := c.Snapshot()
+	assert.Equal(t, int64(1000), snapshot["inc"].Ops["exec"].Events[Allowed])
+	assert.Equal(t, int64(1000), snapshot["inc"].Ops["query"].Events[Dropped])
+	assert.Equal(t, float64(0), snapshot["inc"].DropRatio)
+}
+
+func TestCollector_Observe(t *testing.T) {
+	c := NewCollector()
+	b := breaker.NewBreaker(breaker.WithName("observe"))
+	c.Observe(b, "exec", time.Millisecond)
+	c.Observe(b, "exec", time.Millisecond*30)
+	c.Observe(b, "exec", time.Minute)
+
+	latency := c.Snapshot()["observe"].Ops["exec"].Latency
+	assert.Equal(t, int64(3), latency.Count)
+	assert.InEpsilon(t, 60.031, latency.Sum, 1e-9)
+	assert.Equal(t, int64(1), latency.Buckets["0.001"])
+	assert.Equal(t, int64(1), latency.Buckets["0.025"])
+	assert.Equal(t, int64(2), latency.Buckets["0.05"])
+	assert.Equal(t, int64(2), latency.Buckets["10"])
+}
+
+func TestCollector_ObserveWait(t *testing.T) {
+	c := NewCollector()
+	b := breaker.NewBreaker(breaker.WithName("wait"))
+	c.ObserveWait(b, "exec", time.Millisecond*20)
+
+	op := c.Snapshot()["wait"].Ops["exec"]
+	assert.Equal(t, int64(0), op.Latency.Count)
+	assert.Equal(t, int64(1), op.Wait.Count)
+	assert.Equal(t, int64(1), op.Wait.Buckets["0.025"])
+
+	var buf bytes.Buffer
+	assert.NoError(t, c.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), `sqlbreaker_wait_duration_seconds_count{breaker="wait",op="exec"} 1`)
+}
+
+func TestCollector_Publish(t *testing.T) {
+	c := NewCollector()
+	c.Inc(breaker.NewBreaker(breaker.WithName("publish")), "exec", Accepted)
+	c.Publish("sqlbreaker_test")
+
+	v := expvar.Get("sqlbreaker_test")
+	assert.NotNil(t, v)
+
+	var snapshot map[string]BreakerSnapshot
+	assert.NoError(t, json.Unmarshal([]byte(v.String()), &snapshot))
+	assert.Equal(t, int64(1), snapshot["publish"].Ops["exec"].Events[Accepted])
+
+	assert.Panics(t, func() {
+		c.Publish("sqlbreaker_test")
+	})
+}
+
+func TestCollector_Write(t *testing.T) {
+	c := NewCollector()
+	b := breaker.NewBreaker(breaker.WithName(`a"b`))
+	c.Inc(b, "exec", Allowed)
+	c.Inc(b, "exec", Allowed)
+	c.Inc(b, "exec", Rejected)
+	c.Inc(mockedBreaker{}, "query", Dropped)
+	c.Observe(b, "exec", time.Millisecond*3)
+
+	var buf bytes.Buffer
+	assert.NoError(t, c.WritePrometheus(&buf))
+	assert.Equal(t, `# HELP sqlbreaker_calls_total The number of calls guarded by breakers.
+# TYPE sqlbreaker_calls_total counter
+sqlbreaker_calls_total{breaker="a\"b",op="exec",event="allowed"} 2
+sqlbreaker_calls_total{breaker="a\"b",op="exec",event="rejected"} 1
+sqlbreaker_calls_total{breaker="mocked",op="query",event="dropped"} 1
+# HELP sqlbreaker_drop_ratio The ratio of calls that breakers are dropping.
+# TYPE sqlbreaker_drop_ratio gauge
+sqlbreaker_drop_ratio{breaker="a\"b"} 0
+# HELP sqlbreaker_call_duration_seconds The latency of calls guarded by breakers.
+# TYPE sqlbreaker_call_duration_seconds histogram
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.001"} 0
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.0025"} 0
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.005"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.01"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.025"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.05"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.1"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.25"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="0.5"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="1"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="2.5"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="5"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="10"} 1
+sqlbreaker_call_duration_seconds_bucket{breaker="a\"b",op="exec",le="+Inf"} 1
+sqlbreaker_call_duration_seconds_sum{breaker="a\"b",op="exec"} 0.003
+sqlbreaker_call_duration_seconds_count{breaker="a\"b",op="exec"} 1
+# HELP sqlbreaker_wait_duration_seconds The time calls waited for admission by breakers.
+# TYPE sqlbreaker_wait_duration_seconds histogram
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, c.WriteOpenMetrics(&buf))
+	assert.True(t, strings.HasPrefix(buf.String(), "# HELP sqlbreaker_calls The number"))
+	assert.Contains(t, buf.String(), "# TYPE sqlbreaker_calls counter\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "# EOF\n"))
+}
+
+func TestCollector_ServeHTTP(t *testing.T) {
+	c := NewCollector()
+	c.Inc(breaker.NewBreaker(breaker.WithName("serve")), "exec", Allowed)
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	assert.Equal(t, contentTypePrometheus, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `sqlbreaker_calls_total{breaker="serve",op="exec",event="allowed"} 1`)
+	assert.NotContains(t, w.Body.String(), "# EOF")
+
+	r.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	assert.Equal(t, contentTypeOpenMetrics, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "# EOF")
+}
+
+type mockedBreaker struct{}
+
+func (mockedBreaker) Name() string {
+	return "mocked"
+}
+
+func (mockedBreaker) Allow() (breaker.Promise, error) {
+	return nil, breaker.ErrServiceUnavailable
+}
@@ -0,0 +1,57 @@
+// Package metrics records the events of the calls guarded by breakers,
+// and publishes them via expvar or renders them in the OpenMetrics/Prometheus text format.
+package metrics
+
+import (
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+)
+
+const (
+	// Allowed means the call is allowed by the breaker.
+	Allowed Event = "allowed"
+	// Dropped means the call is dropped by the breaker.
+	Dropped Event = "dropped"
+	// Accepted means the call succeeded and is accepted by the breaker.
+	Accepted Event = "accepted"
+	// Rejected means the call failed and is rejected by the breaker.
+	Rejected Event = "rejected"
+	// Ignored means the call failed with an acceptable error, which is not counted as a failure.
+	Ignored Event = "ignored"
+	// WouldDrop means the call would have been dropped by the breaker but is let through in dry run.
+	WouldDrop Event = "would_drop"
+)
+
+var events = []Event{Allowed, Dropped, Accepted, Rejected, Ignored, WouldDrop}
+
+type (
+	// Event is the kind of event happened to a call.
+	Event string
+
+	// Metrics records the events of the calls guarded by breakers.
+	Metrics interface {
+		// Inc increments the counter of event for the breaker and the operation kind op.
+		Inc(brk breaker.Breaker, op string, event Event)
+		// Observe records the latency of an allowed call for the breaker and the operation kind op.
+		Observe(brk breaker.Breaker, op string, latency time.Duration)
+	}
+
+	// A WaitObserver is a Metrics that also records how long the calls waited for admission.
+	WaitObserver interface {
+		Metrics
+		// ObserveWait records the wait time of a call for the breaker and the operation kind op.
+		ObserveWait(brk breaker.Breaker, op string, wait time.Duration)
+	}
+)
+
+// Nop returns a Metrics that records nothing.
+func Nop() Metrics {
+	return nopMetrics{}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Inc(breaker.Breaker, string, Event) {}
+
+func (nopMetrics) Observe(breaker.Breaker, string, time.Duration) {}
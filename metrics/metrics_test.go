@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNop(t *testing.T) {
+	assert.NotPanics(t, func() {
+		Nop().Inc(breaker.NewBreaker(), "exec", Allowed)
+		Nop().Observe(breaker.NewBreaker(), "exec", time.Second)
+	})
+}
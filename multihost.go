@@ -0,0 +1,205 @@
+package sqlbreaker
+
+import (
+	"context"
+	"database/sql/driver"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+var (
+	_ driver.Connector       = (*multiHostConnector)(nil)
+	_ driver.Validator       = (*hostConn)(nil)
+	_ driver.SessionResetter = (*hostConn)(nil)
+)
+
+const (
+	// RoundRobin opens the connections to the admitting hosts in turn.
+	RoundRobin Selection = iota
+	// LeastLoaded opens the connections to the admitting host with the fewest open connections.
+	LeastLoaded
+)
+
+type (
+	// Selection is how a multi-host connector selects the host of a new connection.
+	Selection int
+
+	// MultiHostOption defines the method to customize a multi-host connector.
+	MultiHostOption func(c *multiHostConnector)
+
+	multiHostConnector struct {
+		hosts      []*host
+		selection  Selection
+		newBreaker func(dsn string) breaker.Breaker
+		hookOpts   []HookOption
+		// next rotates the admitting hosts.
+		next uint64
+	}
+
+	host struct {
+		connector driver.Connector
+		brk       breaker.Breaker
+		// conns is the number of open connections to the host.
+		conns int64
+		// ratio is the sampled drop ratio of brk in math.Float64bits, resampled after expiry, see sampledRatio.
+		ratio  uint64
+		expiry int64
+	}
+
+	// hostConn counts the open connections to its host.
+	hostConn struct {
+		hookedConn
+		host *host
+	}
+)
+
+// NewMultiHostConnector returns a driver.Connector over the hosts of a cluster, e.g. the SQL endpoints of TiDB
+// or CockroachDB, each opened by d with its DSN and guarded by its own breaker, pass it to sql.OpenDB.
+// The new connections go to the hosts whose breakers are admitting, selected by RoundRobin unless
+// WithSelection tells otherwise, and to the next host if a host is unreachable.
+// The hosts whose breakers are shedding are skipped until they recover,
+// unless all of them are, then the ones that shed the least come first.
+// The pooled connections to a host whose breaker starts shedding are discarded by database/sql
+// when they are taken from or put back to the pool, so that the statements move to the healthy hosts,
+// the drop ratios are sampled every 250ms to do so.
+func NewMultiHostConnector(d driver.Driver, dsns []string, opts ...MultiHostOption) (driver.Connector, error) {
+	if len(dsns) == 0 {
+		panic("dsns must not be empty")
+	}
+
+	c := &multiHostConnector{
+		newBreaker: func(string) breaker.Breaker {
+			return breaker.NewBreaker()
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	for _, dsn := range dsns {
+		brk := c.newBreaker(dsn)
+		connector, err := openConnector(brk, d, dsn, c.hookOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		c.hosts = append(c.hosts, &host{connector: connector, brk: brk})
+	}
+
+	return c, nil
+}
+
+// WithSelection returns a MultiHostOption to select the hosts of the new connections by selection.
+func WithSelection(selection Selection) MultiHostOption {
+	return func(c *multiHostConnector) {
+		c.selection = selection
+	}
+}
+
+// WithHostBreaker returns a MultiHostOption to guard each host by the breaker that newBreaker returns for its DSN,
+// e.g. to name and register the breakers. By default, each host is guarded by a breaker.NewBreaker().
+func WithHostBreaker(newBreaker func(dsn string) breaker.Breaker) MultiHostOption {
+	return func(c *multiHostConnector) {
+		c.newBreaker = newBreaker
+	}
+}
+
+// WithHostHook returns a MultiHostOption to customize the hooks of all hosts with opts.
+func WithHostHook(opts ...HookOption) MultiHostOption {
+	return func(c *multiHostConnector) {
+		c.hookOpts = append(c.hookOpts, opts...)
+	}
+}
+
+func (c *multiHostConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var err error
+	for _, h := range c.order() {
+		var conn driver.Conn
+		if conn, err = h.connector.Connect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		atomic.AddInt64(&h.conns, 1)
+		// the connections of sqlplus implement all the optional interfaces.
+		return &hostConn{hookedConn: conn.(hookedConn), host: h}, nil
+	}
+
+	return nil, err
+}
+
+func (c *multiHostConnector) Driver() driver.Driver {
+	return c.hosts[0].connector.Driver()
+}
+
+// order returns the hosts in the order to try, the admitting ones by the selection first,
+// followed by the shedding ones from the one that sheds the least.
+func (c *multiHostConnector) order() []*host {
+	var admitting, shedding []*host
+	ratios := make(map[*host]float64, len(c.hosts))
+	for _, h := range c.hosts {
+		if ratios[h] = dropRatio(h.brk); ratios[h] > 0 {
+			shedding = append(shedding, h)
+		} else {
+			admitting = append(admitting, h)
+		}
+	}
+
+	order := make([]*host, 0, len(c.hosts))
+	if n := len(admitting); n > 0 {
+		offset := int(atomic.AddUint64(&c.next, 1) % uint64(n))
+		order = append(append(order, admitting[offset:]...), admitting[:offset]...)
+		if c.selection == LeastLoaded {
+			sort.SliceStable(order, func(i, j int) bool {
+				return atomic.LoadInt64(&order[i].conns) < atomic.LoadInt64(&order[j].conns)
+			})
+		}
+	}
+
+	sort.SliceStable(shedding, func(i, j int) bool {
+		return ratios[shedding[i]] < ratios[shedding[j]]
+	})
+	return append(order, shedding...)
+}
+
+// IsValid reports false while the breaker of the host is shedding,
+// so that database/sql doesn't put the connection back to the pool.
+func (c *hostConn) IsValid() bool {
+	return c.host.sampledRatio() <= 0
+}
+
+// ResetSession returns driver.ErrBadConn while the breaker of the host is shedding,
+// so that database/sql discards the pooled connection and takes another one.
+func (c *hostConn) ResetSession(_ context.Context) error {
+	if c.host.sampledRatio() > 0 {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+// sampledRatio returns the drop ratio of the breaker of the host, sampled once a ratioInterval,
+// as it's checked every time a connection is taken from or put back to the pool.
+func (h *host) sampledRatio() float64 {
+	now := timex.Now()
+	if now < time.Duration(atomic.LoadInt64(&h.expiry)) {
+		return math.Float64frombits(atomic.LoadUint64(&h.ratio))
+	}
+
+	ratio := dropRatio(h.brk)
+	atomic.StoreUint64(&h.ratio, math.Float64bits(ratio))
+	atomic.StoreInt64(&h.expiry, int64(now+ratioInterval))
+	return ratio
+}
+
+func (c *hostConn) Close() error {
+	atomic.AddInt64(&c.host.conns, -1)
+	return c.hookedConn.Close()
+}
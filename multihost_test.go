@@ -0,0 +1,152 @@
+package sqlbreaker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chenquan/sqlbreaker/pkg/breaker"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMultiHost(t *testing.T, d driver.Driver, opts ...MultiHostOption) (*multiHostConnector, map[string]breaker.Breaker) {
+	brks := make(map[string]breaker.Breaker)
+	opts = append(opts, WithHostBreaker(func(dsn string) breaker.Breaker {
+		brks[dsn] = breaker.NewBreaker(breaker.WithName(dsn), breaker.WithClock(timex.NewManualClock(0)))
+		return brks[dsn]
+	}))
+	connector, err := NewMultiHostConnector(d, []string{"host1", "host2", "host3"}, opts...)
+	assert.NoError(t, err)
+
+	return connector.(*multiHostConnector), brks
+}
+
+// connect opens n connections and returns the DSNs of their hosts.
+func connect(t *testing.T, c *multiHostConnector, n int) []string {
+	var dsns []string
+	for i := 0; i < n; i++ {
+		conn, err := c.Connect(context.Background())
+		if !assert.NoError(t, err) {
+			break
+		}
+
+		for dsn, h := range hostsByDSN(c) {
+			if conn.(*hostConn).host == h {
+				dsns = append(dsns, dsn)
+			}
+		}
+	}
+
+	return dsns
+}
+
+func hostsByDSN(c *multiHostConnector) map[string]*host {
+	return map[string]*host{"host1": c.hosts[0], "host2": c.hosts[1], "host3": c.hosts[2]}
+}
+
+func TestNewMultiHostConnector(t *testing.T) {
+	assert.Panics(t, func() {
+		_, _ = NewMultiHostConnector(&mockedDriver{}, nil)
+	})
+
+	c, brks := newMultiHost(t, &mockedDriver{}, WithSelection(LeastLoaded), WithHostHook(WithDryRun()))
+	assert.Equal(t, LeastLoaded, c.selection)
+	assert.Len(t, c.hookOpts, 1)
+	assert.Len(t, brks, 3)
+	assert.NotNil(t, c.Driver())
+}
+
+func TestMultiHostConnector_RoundRobin(t *testing.T) {
+	c, brks := newMultiHost(t, &mockedDriver{})
+	assert.ElementsMatch(t, []string{"host1", "host2", "host3", "host1", "host2", "host3"}, connect(t, c, 6))
+
+	// the shedding host is skipped.
+	brks["host2"].(breaker.Controller).Force(breaker.StateForcedOpen)
+	assert.ElementsMatch(t, []string{"host1", "host3", "host1", "host3"}, connect(t, c, 4))
+
+	// until it recovers.
+	brks["host2"].(breaker.Controller).Force(breaker.StateAuto)
+	assert.Contains(t, connect(t, c, 3), "host2")
+
+	// the least shedding host comes first if all of them shed.
+	for _, dsn := range []string{"host1", "host3"} {
+		brks[dsn].(breaker.Controller).Force(breaker.StateForcedOpen)
+	}
+	for i := 0; i < 100; i++ {
+		if p, err := brks["host2"].Allow(); err == nil {
+			p.Reject("boom")
+		}
+	}
+	assert.True(t, dropRatio(brks["host2"]) > 0)
+	assert.Equal(t, []string{"host2", "host2"}, connect(t, c, 2))
+}
+
+func TestMultiHostConnector_LeastLoaded(t *testing.T) {
+	c, _ := newMultiHost(t, &mockedDriver{}, WithSelection(LeastLoaded))
+	conn, err := c.Connect(context.Background())
+	assert.NoError(t, err)
+	first := conn.(*hostConn).host
+	connect(t, c, 2)
+	for _, h := range c.hosts {
+		assert.Equal(t, int64(1), h.conns)
+	}
+
+	// the host with a closed connection is the least loaded.
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, int64(0), first.conns)
+	conn, err = c.Connect(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, conn.(*hostConn).host == first)
+}
+
+func TestMultiHostConnector_Unreachable(t *testing.T) {
+	c, _ := newMultiHost(t, &mockedDriver{down: map[string]bool{"host1": true}})
+	dsns := connect(t, c, 4)
+	assert.Len(t, dsns, 4)
+	assert.NotContains(t, dsns, "host1")
+
+	c, _ = newMultiHost(t, &mockedDriver{down: map[string]bool{"host1": true, "host2": true, "host3": true}})
+	_, err := c.Connect(context.Background())
+	assert.EqualError(t, err, "connection refused")
+}
+
+func TestMultiHostConnector_Pooled(t *testing.T) {
+	d := &mockedDriver{}
+	c, brks := newMultiHost(t, d)
+	db := sql.OpenDB(c)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	_, err := db.Exec("delete from t")
+	assert.NoError(t, err)
+	served := d.take()
+	assert.Len(t, served, 1)
+
+	// the pooled connection to the shedding host is discarded, the next statement goes to another host.
+	brks[served[0]].(breaker.Controller).Force(breaker.StateForcedOpen)
+	for _, h := range c.hosts {
+		atomic.StoreInt64(&h.expiry, 0)
+	}
+	_, err = db.Exec("delete from t")
+	assert.NoError(t, err)
+	next := d.take()
+	assert.Len(t, next, 1)
+	assert.NotEqual(t, served[0], next[0])
+	assert.Equal(t, 1, d.opened[served[0]])
+	assert.Equal(t, int64(0), hostsByDSN(c)[served[0]].conns)
+}
+
+func TestMultiHostConnector_OpenDB(t *testing.T) {
+	d := &mockedDriver{}
+	connector, err := NewMultiHostConnector(d, []string{"host1", "host2"})
+	assert.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	_, err = db.Exec("insert into t values (?)", 1)
+	assert.NoError(t, err)
+	assert.Len(t, d.take(), 1)
+}
@@ -0,0 +1,287 @@
+package breaker
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+const (
+	// the limit is updated at most once per interval, with the mean latency of the interval.
+	adaptiveInterval = time.Millisecond * 250
+	// the no-load latency is estimated with the latencies in the window.
+	noLoadWindow  = time.Second * 30
+	noLoadBuckets = 30
+	// the quantile of latencies taken as the no-load latency, lower ones are too noisy.
+	noLoadQuantile = 0.05
+	// how much the latency may go over the no-load latency before the limit shrinks.
+	defaultTolerance = 1.5
+	// how much the new limit weighs in the smoothed limit.
+	adaptiveSmoothing = 0.2
+	// the limit shrinks at most by half per interval.
+	minGradient = 0.5
+
+	paramMinLimit  = "minLimit"
+	paramMaxLimit  = "maxLimit"
+	paramTolerance = "tolerance"
+)
+
+// ErrLimitExceeded is returned when the calls in flight reach the adaptive limit.
+var ErrLimitExceeded = errors.New("concurrency limit exceeded")
+
+// adaptiveLimiter limits the calls in flight, the limit adapts to the latencies like the gradient algorithm:
+// it shrinks when the latency goes over tolerance * the no-load latency, and grows by sqrt(limit) otherwise.
+type adaptiveLimiter struct {
+	// lock guards all below but stat and noLoad.
+	lock      sync.Mutex
+	limit     float64
+	minLimit  float64
+	maxLimit  float64
+	tolerance float64
+	inFlight  int64
+
+	// the latencies since the last update
+	sum         time.Duration
+	count       int64
+	maxInFlight int64
+	lastUpdate  time.Duration
+
+	clock timex.Clock
+	// admitted calls are counted as 1, the calls that are not as 0.
+	stat windowStat
+	// latencies in seconds
+	noLoad *collection.RollingHistogram
+}
+
+// NewAdaptiveLimiter returns a Breaker that limits the calls in flight,
+// the limit starts at initialLimit and adapts between 1 and maxLimit to the latencies of calls.
+// It shrinks when the latency goes over the no-load latency, which is estimated from the fastest calls in the last 30 seconds,
+// and grows while the latency stays close to it. The calls over the limit fail with ErrLimitExceeded.
+// Only the calls reported by LatencyPromise are taken into account.
+func NewAdaptiveLimiter(initialLimit, maxLimit int, opts ...Option) Breaker {
+	checkAdaptiveLimiter(initialLimit, maxLimit)
+
+	return newCircuitBreaker(func(opts throttleOptions) internalThrottle {
+		return newAdaptiveLimiter(initialLimit, maxLimit, opts.clock)
+	}, opts...)
+}
+
+// WithAdaptiveLimiter returns a function to put an adaptive limiter in front of a Breaker, see NewAdaptiveLimiter.
+func WithAdaptiveLimiter(initialLimit, maxLimit int) Option {
+	checkAdaptiveLimiter(initialLimit, maxLimit)
+
+	return func(b *circuitBreaker) {
+		b.front = append(b.front, func(opts throttleOptions) internalThrottle {
+			return newAdaptiveLimiter(initialLimit, maxLimit, opts.clock)
+		})
+	}
+}
+
+func checkAdaptiveLimiter(initialLimit, maxLimit int) {
+	if initialLimit < 1 {
+		panic("initialLimit must be greater than 0")
+	}
+	if maxLimit < initialLimit {
+		panic("maxLimit must not be less than initialLimit")
+	}
+}
+
+func newAdaptiveLimiter(initialLimit, maxLimit int, clock timex.Clock) *adaptiveLimiter {
+	bucketDuration := time.Duration(int64(window) / int64(buckets))
+	return &adaptiveLimiter{
+		limit:      float64(initialLimit),
+		minLimit:   1,
+		maxLimit:   float64(maxLimit),
+		tolerance:  defaultTolerance,
+		lastUpdate: clock.Now(),
+		clock:      clock,
+		stat: windowStat{collection.NewAtomicRollingWindow(buckets, bucketDuration,
+			collection.WithAtomicRollingWindowClock(clock))},
+		noLoad: collection.NewRollingHistogram(noLoadBuckets, noLoadWindow/noLoadBuckets,
+			collection.WithRollingHistogramClock(clock)),
+	}
+}
+
+func (l *adaptiveLimiter) allow() (internalPromise, error) {
+	l.lock.Lock()
+	if float64(l.inFlight) >= math.Floor(l.limit) {
+		l.lock.Unlock()
+		l.stat.add(0)
+		return nil, ErrLimitExceeded
+	}
+	l.acquire()
+	l.lock.Unlock()
+
+	l.stat.add(1)
+	return adaptivePromise{l: l}, nil
+}
+
+// promise takes a slot even if the limit is reached.
+func (l *adaptiveLimiter) promise() internalPromise {
+	l.lock.Lock()
+	l.acquire()
+	l.lock.Unlock()
+
+	return adaptivePromise{l: l}
+}
+
+// acquire takes a slot, l.lock must be held.
+func (l *adaptiveLimiter) acquire() {
+	l.inFlight++
+	if l.inFlight > l.maxInFlight {
+		l.maxInFlight = l.inFlight
+	}
+}
+
+func (l *adaptiveLimiter) release() {
+	l.lock.Lock()
+	l.inFlight--
+	l.lock.Unlock()
+}
+
+func (l *adaptiveLimiter) add(latency time.Duration) {
+	l.noLoad.Add(latency.Seconds())
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.inFlight--
+	l.sum += latency
+	l.count++
+
+	now := l.clock.Now()
+	if now-l.lastUpdate < adaptiveInterval {
+		return
+	}
+
+	rtt := l.sum.Seconds() / float64(l.count)
+	l.update(rtt, l.noLoad.Quantile(noLoadQuantile))
+	l.sum = 0
+	l.count = 0
+	l.maxInFlight = l.inFlight
+	l.lastUpdate = now
+}
+
+// update adapts the limit to the mean latency rtt against the no-load latency, l.lock must be held.
+func (l *adaptiveLimiter) update(rtt, noLoad float64) {
+	if rtt <= 0 || noLoad <= 0 {
+		return
+	}
+
+	gradient := math.Max(minGradient, math.Min(1, l.tolerance*noLoad/rtt))
+	limit := l.limit*gradient + math.Sqrt(l.limit)
+	// don't grow if the limit is not reached, the latencies tell nothing about a higher load.
+	if limit > l.limit && float64(l.maxInFlight) < l.limit/2 {
+		return
+	}
+
+	limit = l.limit*(1-adaptiveSmoothing) + limit*adaptiveSmoothing
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, limit))
+}
+
+func (l *adaptiveLimiter) stats() Stats {
+	accepts, total := l.stat.history()
+
+	l.lock.Lock()
+	inFlight := l.inFlight
+	params := map[string]float64{
+		paramLimit:     l.limit,
+		paramMinLimit:  l.minLimit,
+		paramMaxLimit:  l.maxLimit,
+		paramTolerance: l.tolerance,
+	}
+	l.lock.Unlock()
+
+	var dropRatio float64
+	if total > 0 {
+		dropRatio = 1 - accepts/total
+	}
+
+	return Stats{
+		Accepts:   int64(accepts),
+		Total:     int64(total),
+		DropRatio: dropRatio,
+		Params:    params,
+		InFlight:  inFlight,
+	}
+}
+
+// reset clears the statistics, the limit and the calls in flight are kept.
+func (l *adaptiveLimiter) reset() {
+	l.stat.reset()
+	l.noLoad.Reset()
+
+	l.lock.Lock()
+	l.sum = 0
+	l.count = 0
+	l.lastUpdate = l.clock.Now()
+	l.lock.Unlock()
+}
+
+func (l *adaptiveLimiter) tune(param string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 1 {
+		return ErrInvalidParam
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	switch param {
+	case paramLimit:
+		if value < l.minLimit || value > l.maxLimit {
+			return ErrInvalidParam
+		}
+		l.limit = value
+	case paramMinLimit:
+		if value > l.maxLimit {
+			return ErrInvalidParam
+		}
+		l.minLimit = value
+		l.limit = math.Max(l.limit, value)
+	case paramMaxLimit:
+		if value < l.minLimit {
+			return ErrInvalidParam
+		}
+		l.maxLimit = value
+		l.limit = math.Min(l.limit, value)
+	case paramTolerance:
+		l.tolerance = value
+	default:
+		return ErrUnknownParam
+	}
+
+	return nil
+}
+
+type adaptivePromise struct {
+	l *adaptiveLimiter
+}
+
+// Accept is called without latency, nothing to learn from.
+func (p adaptivePromise) Accept() {
+	p.l.release()
+}
+
+// Reject is called without latency, nothing to learn from.
+func (p adaptivePromise) Reject() {
+	p.l.release()
+}
+
+func (p adaptivePromise) acceptWithLatency(latency time.Duration) {
+	p.l.add(latency)
+}
+
+func (p adaptivePromise) rejectWithLatency(latency time.Duration) {
+	p.l.add(latency)
+}
+
+// cancel gives the slot back if the call is dropped by a throttle behind.
+func (p adaptivePromise) cancel() {
+	p.l.release()
+}
+
+func (p adaptivePromise) stateless() {}
@@ -0,0 +1,130 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAdaptiveLimiter(t *testing.T) {
+	assert.Panics(t, func() {
+		NewAdaptiveLimiter(0, 1)
+	})
+	assert.Panics(t, func() {
+		NewAdaptiveLimiter(2, 1)
+	})
+	assert.Panics(t, func() {
+		WithAdaptiveLimiter(0, 1)
+	})
+
+	b := NewAdaptiveLimiter(1, 1, WithName("adaptive"))
+	assert.Equal(t, "adaptive", b.Name())
+}
+
+func TestAdaptiveLimiter_Allow(t *testing.T) {
+	l := newAdaptiveLimiter(2, 10, timex.NewManualClock(0))
+	p1, err := l.allow()
+	assert.NoError(t, err)
+	p2, err := l.allow()
+	assert.NoError(t, err)
+	_, err = l.allow()
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.Equal(t, int64(2), l.stats().InFlight)
+
+	p1.Accept()
+	p2.(internalLatencyPromise).rejectWithLatency(time.Millisecond)
+	p3, err := l.allow()
+	assert.NoError(t, err)
+	p3.(cancelablePromise).cancel()
+
+	stats := l.stats()
+	assert.Equal(t, int64(0), stats.InFlight)
+	assert.Equal(t, int64(3), stats.Accepts)
+	assert.Equal(t, int64(4), stats.Total)
+	assert.Equal(t, 0.25, stats.DropRatio)
+	assert.Equal(t, float64(2), stats.Params[paramLimit])
+	assert.Equal(t, float64(1), stats.Params[paramMinLimit])
+	assert.Equal(t, float64(10), stats.Params[paramMaxLimit])
+	assert.Equal(t, defaultTolerance, stats.Params[paramTolerance])
+
+	l.reset()
+	assert.Equal(t, int64(0), l.stats().Total)
+}
+
+func TestAdaptiveLimiter_Adapt(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	l := newAdaptiveLimiter(10, 100, clock)
+
+	// round runs as many calls as the limit allows, if saturated, or n calls, all taking latency.
+	round := func(n int, saturated bool, latency time.Duration) {
+		var promises []internalPromise
+		for i := 0; saturated || i < n; i++ {
+			p, err := l.allow()
+			if err != nil {
+				break
+			}
+			promises = append(promises, p)
+		}
+		clock.Advance(adaptiveInterval)
+		for _, p := range promises {
+			p.(internalLatencyPromise).acceptWithLatency(latency)
+		}
+	}
+
+	t.Run("not saturated", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			round(2, false, time.Millisecond*10)
+		}
+		assert.Equal(t, float64(10), l.stats().Params[paramLimit])
+	})
+
+	t.Run("grow", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			round(0, true, time.Millisecond*10)
+		}
+		assert.True(t, l.stats().Params[paramLimit] > 15)
+	})
+
+	t.Run("shrink", func(t *testing.T) {
+		before := l.stats().Params[paramLimit]
+		for i := 0; i < 20; i++ {
+			round(0, true, time.Millisecond*100)
+		}
+		limit := l.stats().Params[paramLimit]
+		assert.True(t, limit < before/2)
+		assert.True(t, limit >= 1)
+	})
+
+	assert.Equal(t, int64(0), l.stats().InFlight)
+}
+
+func TestAdaptiveLimiter_Tune(t *testing.T) {
+	l := newAdaptiveLimiter(5, 10, timex.NewManualClock(0))
+	assert.NoError(t, l.tune(paramLimit, 8))
+	assert.NoError(t, l.tune(paramTolerance, 2))
+	assert.NoError(t, l.tune(paramMaxLimit, 6))
+	assert.Equal(t, float64(6), l.limit)
+	assert.NoError(t, l.tune(paramMinLimit, 3))
+	assert.ErrorIs(t, l.tune(paramLimit, 7), ErrInvalidParam)
+	assert.ErrorIs(t, l.tune(paramMinLimit, 7), ErrInvalidParam)
+	assert.ErrorIs(t, l.tune(paramMaxLimit, 2), ErrInvalidParam)
+	assert.ErrorIs(t, l.tune(paramTolerance, 0), ErrInvalidParam)
+	assert.ErrorIs(t, l.tune("any", 1), ErrUnknownParam)
+	assert.Equal(t, float64(2), l.tolerance)
+}
+
+func TestWithAdaptiveLimiter(t *testing.T) {
+	b := NewBreaker(WithAdaptiveLimiter(1, 10)).(*circuitBreaker)
+	p, err := b.Allow()
+	assert.NoError(t, err)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.Equal(t, int64(1), b.Stats().InFlight)
+
+	p.(LatencyPromise).AcceptWithLatency("query", time.Millisecond)
+	assert.Equal(t, int64(0), b.Stats().InFlight)
+	assert.Equal(t, float64(1), b.Stats().Params[paramLimit])
+	assert.NoError(t, b.Tune(paramLimit, 2))
+}
@@ -3,19 +3,50 @@ package breaker
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/mathx"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
 )
 
 const (
 	numHistoryReasons = 5
 	timeFormat        = "15:04:05"
+	// unbounded means no bound on the wait for admission, see BoundedBreaker.
+	unbounded = time.Duration(math.MaxInt64)
+)
+
+const (
+	// StateAuto means the Breaker decides by itself whether to allow a request.
+	StateAuto State = iota
+	// StateForcedOpen means the Breaker rejects all requests.
+	StateForcedOpen
+	// StateForcedClosed means the Breaker allows all requests.
+	StateForcedClosed
+)
+
+const (
+	// RampLinear ramps the admission ratio up linearly.
+	RampLinear Ramp = iota
+	// RampExponential ramps the admission ratio up exponentially, slowly at first and fast at last.
+	RampExponential
 )
 
-// ErrServiceUnavailable is returned when the Breaker state is open.
-var ErrServiceUnavailable = errors.New("circuit breaker is open")
+var (
+	// ErrServiceUnavailable is returned when the Breaker state is open.
+	ErrServiceUnavailable = errors.New("circuit breaker is open")
+	// ErrUnknownParam is returned when tuning a parameter that the Breaker doesn't have.
+	ErrUnknownParam = errors.New("unknown breaker parameter")
+	// ErrInvalidParam is returned when tuning a parameter with an invalid value.
+	ErrInvalidParam = errors.New("invalid breaker parameter value")
+)
 
 type (
 	// Acceptable is the func to check if the error can be accepted.
@@ -33,6 +64,86 @@ type (
 		Allow() (Promise, error)
 	}
 
+	// A KeyedBreaker is a Breaker that can also allow requests by key,
+	// the limits set for the key take effect on top of the ones for all requests, see WithKeyRateLimit.
+	KeyedBreaker interface {
+		Breaker
+		// AllowKey checks if the request of key is allowed, like Allow.
+		AllowKey(key string) (Promise, error)
+	}
+
+	// An Inspector is a Breaker that can report its statistics.
+	Inspector interface {
+		Breaker
+		// Stats returns the current statistics of the Breaker.
+		Stats() Stats
+	}
+
+	// A BoundedBreaker is a KeyedBreaker that can bound how long a request waits for admission,
+	// e.g. to the deadline of the caller, see NewBulkhead and NewCoDel.
+	BoundedBreaker interface {
+		KeyedBreaker
+		// AllowWithin checks if the request of key is allowed like AllowKey, but waits no longer than maxWait,
+		// an empty key means the request has no key.
+		AllowWithin(key string, maxWait time.Duration) (Promise, error)
+	}
+
+	// A WaitRecorder is a Breaker that records how long the requests waited to be admitted by retrying,
+	// the waits are reported in Stats.WaitTime and Stats.WaitTimeouts.
+	WaitRecorder interface {
+		Breaker
+		// RecordWait records that a request waited for wait, and whether it timed out rather than being admitted.
+		RecordWait(wait time.Duration, timedOut bool)
+	}
+
+	// A Controller is a Breaker that can be operated manually at runtime.
+	Controller interface {
+		Breaker
+		// Force forces the Breaker into the given state, StateAuto releases it.
+		Force(state State)
+		// Reset clears the statistics and releases the forced state.
+		Reset()
+		// Tune changes the parameter of the Breaker to value.
+		Tune(param string, value float64) error
+	}
+
+	// State represents the manually set state of a Breaker.
+	State int32
+
+	// Ramp is the curve of the slow start after recovery, see WithSlowStart.
+	Ramp int
+
+	// Stats is the statistics of a Breaker.
+	Stats struct {
+		Name      string             `json:"name"`
+		State     State              `json:"state"`
+		Accepts   int64              `json:"accepts"`
+		Total     int64              `json:"total"`
+		DropRatio float64            `json:"dropRatio"`
+		Params    map[string]float64 `json:"params,omitempty"`
+		Reasons   []string           `json:"reasons,omitempty"`
+		// Latency is the latency of all calls reported by LatencyPromise.
+		Latency LatencyStats `json:"latency"`
+		// OpLatency is the latency per operation kind.
+		OpLatency map[string]LatencyStats `json:"opLatency,omitempty"`
+		// DryRun tells if the Breaker lets all calls through, see WithDryRun.
+		DryRun bool `json:"dryRun,omitempty"`
+		// WouldDrop is the number of calls that would have been dropped in dry run since the last Reset.
+		WouldDrop int64 `json:"wouldDrop,omitempty"`
+		// InFlight is the number of calls in flight, see NewBulkhead.
+		InFlight int64 `json:"inFlight,omitempty"`
+		// QueueTime is how long the calls waited for admission.
+		QueueTime LatencyStats `json:"queueTime"`
+		// WaitTime is how long the calls waited to be admitted by retrying, see WaitRecorder.
+		WaitTime LatencyStats `json:"waitTime"`
+		// WaitTimeouts is the number of calls that were not admitted in time by retrying since the last Reset.
+		WaitTimeouts int64 `json:"waitTimeouts,omitempty"`
+		// Warmup is the progress of the slow start after recovery in (0, 1), 0 if not warming up, see WithSlowStart.
+		Warmup float64 `json:"warmup,omitempty"`
+		// Overloaded tells if the Breaker sheds the calls queued for too long, see NewCoDel.
+		Overloaded bool `json:"overloaded,omitempty"`
+	}
+
 	// Option defines the method to customize a Breaker.
 	Option func(breaker *circuitBreaker)
 
@@ -44,28 +155,111 @@ type (
 		Reject(reason string)
 	}
 
+	// A LatencyPromise is a Promise that can also be told how long the call took.
+	LatencyPromise interface {
+		Promise
+		// AcceptWithLatency tells the Breaker that the call of the operation kind op is successful and took latency.
+		AcceptWithLatency(op string, latency time.Duration)
+		// RejectWithLatency tells the Breaker that the call of the operation kind op is failed and took latency.
+		RejectWithLatency(op, reason string, latency time.Duration)
+	}
+
 	internalPromise interface {
 		Accept()
 		Reject()
 	}
 
+	// statelessPromise is implemented by the internal promises that don't carry any state of the call,
+	// the throttle returns equal ones for all calls, so that it can be wrapped only once.
+	statelessPromise interface {
+		internalPromise
+		stateless()
+	}
+
+	// internalLatencyPromise is implemented by the internal promises that take latencies into account.
+	internalLatencyPromise interface {
+		internalPromise
+		acceptWithLatency(latency time.Duration)
+		rejectWithLatency(latency time.Duration)
+	}
+
 	circuitBreaker struct {
-		name string
+		name  string
+		state int32
+		throttleOptions
 		throttle
 	}
 
+	// throttleOptions are the options that take effect on creating the internal throttle.
+	throttleOptions struct {
+		// halfLife makes the googleBreaker decay its statistics exponentially instead of using a rolling window.
+		halfLife time.Duration
+		clock    timex.Clock
+		// randSource is the source of the random drop decisions.
+		randSource rand.Source
+		// diffusion makes the drop decisions by error diffusion instead of randomly.
+		diffusion bool
+		dryRun    bool
+		// slowStart is the period of the ramp after recovery, see WithSlowStart.
+		slowStart time.Duration
+		ramp      Ramp
+		// maxDropRatio and probeInterval keep the googleBreaker admitting some requests while shedding.
+		maxDropRatio  float64
+		probeInterval time.Duration
+		// keyRates are the rate limits by key, see WithKeyRateLimit.
+		keyRates map[string]rateLimit
+		// front are the throttles in front of the main one, see WithBulkhead.
+		front []func(opts throttleOptions) internalThrottle
+	}
+
+	// keyedThrottle is implemented by the internal throttles that have limits by key.
+	keyedThrottle interface {
+		allowKey(key string) (internalPromise, error)
+	}
+
+	// boundedThrottle is implemented by the internal throttles that make requests wait for admission,
+	// the wait is bounded by maxWait on top of their own limits.
+	boundedThrottle interface {
+		allowWithin(key string, maxWait time.Duration) (internalPromise, error)
+	}
+
+	// recoverableThrottle is implemented by the internal throttles that recover other than by reset,
+	// e.g. with a slow start, see NewProber.
+	recoverableThrottle interface {
+		recover()
+	}
+
 	internalThrottle interface {
 		allow() (internalPromise, error)
+		// promise returns a promise without checking whether the request is allowed.
+		promise() internalPromise
+		stats() Stats
+		reset()
+		tune(param string, value float64) error
 	}
 
 	throttle interface {
 		allow() (Promise, error)
+		allowKey(key string) (Promise, error)
+		allowWithin(key string, maxWait time.Duration) (Promise, error)
+		recordWait(wait time.Duration, timedOut bool)
+		promise() Promise
+		stats() Stats
+		reset()
+		recover()
+		tune(param string, value float64) error
 	}
 )
 
 // NewBreaker returns a Breaker object.
 // opts can be used to customize the Breaker.
 func NewBreaker(opts ...Option) Breaker {
+	return newCircuitBreaker(func(opts throttleOptions) internalThrottle {
+		return newGoogleBreaker(opts)
+	}, opts...)
+}
+
+func newCircuitBreaker(newThrottle func(opts throttleOptions) internalThrottle, opts ...Option) *circuitBreaker {
 	var b circuitBreaker
 	for _, opt := range opts {
 		opt(&b)
@@ -74,19 +268,98 @@ func NewBreaker(opts ...Option) Breaker {
 	if len(b.name) == 0 {
 		b.name = strconv.FormatInt(time.Now().UnixMilli(), 10)
 	}
-	b.throttle = newLoggedThrottle(b.name, newGoogleBreaker())
+	if b.clock == nil {
+		b.clock = timex.RealClock()
+	}
+	t := newThrottle(b.throttleOptions)
+	if len(b.front) > 0 {
+		throttles := make([]internalThrottle, 0, len(b.front)+1)
+		for _, newFront := range b.front {
+			throttles = append(throttles, newFront(b.throttleOptions))
+		}
+		t = newChainThrottle(append(throttles, t)...)
+	}
+	b.throttle = newLoggedThrottle(b.name, t, b.throttleOptions)
 
 	return &b
 }
 
 func (cb *circuitBreaker) Allow() (Promise, error) {
-	return cb.throttle.allow()
+	switch cb.currentState() {
+	case StateForcedOpen:
+		return nil, ErrServiceUnavailable
+	case StateForcedClosed:
+		return cb.throttle.promise(), nil
+	default:
+		return cb.throttle.allow()
+	}
+}
+
+func (cb *circuitBreaker) AllowKey(key string) (Promise, error) {
+	switch cb.currentState() {
+	case StateForcedOpen:
+		return nil, ErrServiceUnavailable
+	case StateForcedClosed:
+		return cb.throttle.promise(), nil
+	default:
+		return cb.throttle.allowKey(key)
+	}
+}
+
+func (cb *circuitBreaker) AllowWithin(key string, maxWait time.Duration) (Promise, error) {
+	switch cb.currentState() {
+	case StateForcedOpen:
+		return nil, ErrServiceUnavailable
+	case StateForcedClosed:
+		return cb.throttle.promise(), nil
+	default:
+		return cb.throttle.allowWithin(key, maxWait)
+	}
+}
+
+func (cb *circuitBreaker) RecordWait(wait time.Duration, timedOut bool) {
+	cb.throttle.recordWait(wait, timedOut)
 }
 
 func (cb *circuitBreaker) Name() string {
 	return cb.name
 }
 
+func (cb *circuitBreaker) Stats() Stats {
+	stats := cb.throttle.stats()
+	stats.Name = cb.name
+	stats.State = cb.currentState()
+
+	return stats
+}
+
+func (cb *circuitBreaker) Force(state State) {
+	atomic.StoreInt32(&cb.state, int32(state))
+}
+
+func (cb *circuitBreaker) Reset() {
+	cb.throttle.reset()
+	cb.Force(StateAuto)
+}
+
+func (cb *circuitBreaker) Tune(param string, value float64) error {
+	return cb.throttle.tune(param, value)
+}
+
+func (cb *circuitBreaker) probePromise() Promise {
+	return cb.throttle.promise()
+}
+
+func (cb *circuitBreaker) recover() {
+	if cb.currentState() == StateAuto {
+		cb.throttle.recover()
+	}
+}
+
+func (cb *circuitBreaker) currentState() State {
+	return State(atomic.LoadInt32(&cb.state))
+}
+
 // WithName returns a function to set the name of a Breaker.
 func WithName(name string) Option {
 	return func(b *circuitBreaker) {
@@ -94,30 +367,270 @@ func WithName(name string) Option {
 	}
 }
 
+// WithDecay returns a function to make a Breaker decay its statistics exponentially with halfLife,
+// instead of counting them in a rolling window of 10 seconds.
+// The old requests fade out smoothly rather than expiring all at once,
+// a halfLife of about 7 seconds weighs the requests like the default window.
+// It only takes effect on the Breaker returned by NewBreaker.
+func WithDecay(halfLife time.Duration) Option {
+	return func(b *circuitBreaker) {
+		b.halfLife = halfLife
+	}
+}
+
+// WithClock returns a function to make a Breaker tell the time with clock,
+// which is useful to advance the time by hand in tests and simulations.
+func WithClock(clock timex.Clock) Option {
+	return func(b *circuitBreaker) {
+		b.clock = clock
+	}
+}
+
+// WithSeed returns a function to make the random drop decisions of a Breaker reproducible with seed.
+// Each Breaker given the function draws from a source of its own.
+func WithSeed(seed int64) Option {
+	return func(b *circuitBreaker) {
+		b.randSource = &lockedSource{src: rand.NewSource(seed)}
+	}
+}
+
+// WithRandSource returns a function to make a Breaker generate the random numbers for drop decisions from src.
+// src doesn't have to be safe for concurrent use, the throttles of the Breaker,
+// and the Breakers given the same function, take turns to draw from it.
+func WithRandSource(src rand.Source) Option {
+	locked := &lockedSource{src: src}
+	return func(b *circuitBreaker) {
+		b.randSource = locked
+	}
+}
+
+// WithErrorDiffusion returns a function to make a Breaker drop exactly the drop ratio of requests without randomness,
+// the drops are spread evenly, which suits low QPS services where random drops look bursty.
+func WithErrorDiffusion() Option {
+	return func(b *circuitBreaker) {
+		b.diffusion = true
+	}
+}
+
+// WithDryRun returns a function to make a Breaker let all calls through,
+// the calls it would have dropped are counted in Stats.WouldDrop and logged in Stats.Reasons.
+// The outcomes of those calls are not recorded, as if they were dropped,
+// so that the Breaker behaves the same as when enforced, which helps to tune it against real traffic.
+// Forcing the Breaker open still drops calls.
+func WithDryRun() Option {
+	return func(b *circuitBreaker) {
+		b.dryRun = true
+	}
+}
+
+// WithSlowStart returns a function to make a Breaker warm up after recovery,
+// once it stops dropping requests, it caps the ratio of admitted requests from 10% up to all in period along ramp,
+// so that the backlog doesn't hit a cold database at once. The progress is reported in Stats.Warmup.
+// It only takes effect on the Breaker returned by NewBreaker.
+func WithSlowStart(period time.Duration, ramp Ramp) Option {
+	return func(b *circuitBreaker) {
+		b.slowStart = period
+		b.ramp = ramp
+	}
+}
+
+// WithMaxDropRatio returns a function to cap the drop ratio of a Breaker at ratio,
+// so that it keeps admitting 1-ratio of requests to observe the database and recover promptly.
+// A ratio of 0 leaves the drop ratio uncapped. It only takes effect on the Breaker returned by NewBreaker.
+func WithMaxDropRatio(ratio float64) Option {
+	if ratio < 0 || ratio > 1 {
+		panic("ratio must be in [0, 1]")
+	}
+
+	return func(b *circuitBreaker) {
+		b.maxDropRatio = ratio
+	}
+}
+
+// WithProbeInterval returns a function to make a Breaker admit a request as a probe
+// if it has admitted none for interval while shedding, so that it recovers promptly even if the traffic is low.
+// It only takes effect on the Breaker returned by NewBreaker.
+func WithProbeInterval(interval time.Duration) Option {
+	if interval < 0 {
+		panic("interval must not be negative")
+	}
+
+	return func(b *circuitBreaker) {
+		b.probeInterval = interval
+	}
+}
+
+// lockedSource serializes the draws from src, which is shared by the deciders of the throttles.
+type lockedSource struct {
+	lock sync.Mutex
+	src  rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s64, ok := s.src.(rand.Source64); ok {
+		return s64.Uint64()
+	}
+
+	return uint64(s.src.Int63())>>31 | uint64(s.src.Int63())<<32
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.src.Seed(seed)
+}
+
+func (o throttleOptions) newDecider() mathx.Decider {
+	switch {
+	case o.diffusion:
+		return mathx.NewDiffusion()
+	case o.randSource != nil:
+		return mathx.NewProbaWithSource(o.randSource)
+	default:
+		return mathx.NewProba()
+	}
+}
+
 type loggedThrottle struct {
 	name string
 	internalThrottle
-	errWin *errorWindow
+	errWin    *errorWindow
+	latencies *latencyRecorder
+	// shared holds the Promise that wraps the statelessPromise of the internal throttle if any,
+	// which saves an allocation on every call.
+	shared *atomic.Value
+	dryRun bool
+	// wouldDrop counts the calls that would have been dropped in dry run.
+	wouldDrop *int64
+	// waits are the wait times in seconds, see WaitRecorder.
+	waits *collection.AtomicRollingHistogram
+	// waitTimeouts counts the waits that timed out.
+	waitTimeouts *int64
 }
 
-func newLoggedThrottle(name string, t internalThrottle) loggedThrottle {
-	return loggedThrottle{
+func newLoggedThrottle(name string, t internalThrottle, opts throttleOptions) loggedThrottle {
+	lt := loggedThrottle{
 		name:             name,
 		internalThrottle: t,
 		errWin:           new(errorWindow),
+		latencies:        newLatencyRecorder(opts.clock),
+		shared:           new(atomic.Value),
+		dryRun:           opts.dryRun,
+		wouldDrop:        new(int64),
+		waits:            newLatencyHistogram(opts.clock),
+		waitTimeouts:     new(int64),
 	}
+
+	return lt
 }
 
 func (lt loggedThrottle) allow() (Promise, error) {
-	promise, err := lt.internalThrottle.allow()
+	return lt.admit(lt.internalThrottle.allow())
+}
+
+func (lt loggedThrottle) allowKey(key string) (Promise, error) {
+	if kt, ok := lt.internalThrottle.(keyedThrottle); ok {
+		return lt.admit(kt.allowKey(key))
+	}
+
+	return lt.allow()
+}
+
+func (lt loggedThrottle) allowWithin(key string, maxWait time.Duration) (Promise, error) {
+	if bt, ok := lt.internalThrottle.(boundedThrottle); ok {
+		return lt.admit(bt.allowWithin(key, maxWait))
+	}
+
+	return lt.allowKey(key)
+}
+
+func (lt loggedThrottle) admit(promise internalPromise, err error) (Promise, error) {
 	if err != nil {
+		if lt.dryRun {
+			atomic.AddInt64(lt.wouldDrop, 1)
+			lt.errWin.add(fmt.Sprintf("dry run: would have dropped: %s", err))
+			return dryRunPromise{}, nil
+		}
 		return nil, err
 	}
 
-	return promiseWithReason{
-		promise: promise,
-		errWin:  lt.errWin,
-	}, err
+	return lt.wrap(promise), nil
+}
+
+func (lt loggedThrottle) promise() Promise {
+	return lt.wrap(lt.internalThrottle.promise())
+}
+
+func (lt loggedThrottle) wrap(promise internalPromise) Promise {
+	_, stateless := promise.(statelessPromise)
+	if stateless {
+		if shared, ok := lt.shared.Load().(Promise); ok {
+			return shared
+		}
+	}
+
+	var wrapped Promise = promiseWithReason{
+		promise:   promise,
+		errWin:    lt.errWin,
+		latencies: lt.latencies,
+	}
+	if stateless {
+		lt.shared.Store(wrapped)
+	}
+
+	return wrapped
+}
+
+func (lt loggedThrottle) stats() Stats {
+	stats := lt.internalThrottle.stats()
+	stats.Reasons = lt.errWin.list()
+	stats.Latency, stats.OpLatency = lt.latencies.stats()
+	stats.DryRun = lt.dryRun
+	stats.WouldDrop = atomic.LoadInt64(lt.wouldDrop)
+	waits := lt.waits.Snapshot()
+	stats.WaitTime = newLatencyStats(&waits)
+	stats.WaitTimeouts = atomic.LoadInt64(lt.waitTimeouts)
+
+	return stats
+}
+
+func (lt loggedThrottle) reset() {
+	lt.internalThrottle.reset()
+	lt.errWin.reset()
+	lt.latencies.reset()
+	atomic.StoreInt64(lt.wouldDrop, 0)
+	lt.waits.Reset()
+	atomic.StoreInt64(lt.waitTimeouts, 0)
+}
+
+func (lt loggedThrottle) recordWait(wait time.Duration, timedOut bool) {
+	lt.waits.Add(wait.Seconds())
+	if timedOut {
+		atomic.AddInt64(lt.waitTimeouts, 1)
+	}
+}
+
+// recover clears the statistics of the requests, the latencies and the dry run counts are kept.
+func (lt loggedThrottle) recover() {
+	recoverThrottle(lt.internalThrottle)
+	lt.errWin.reset()
+}
+
+// recoverThrottle recovers t, by resetting it unless it recovers otherwise.
+func recoverThrottle(t internalThrottle) {
+	if rt, ok := t.(recoverableThrottle); ok {
+		rt.recover()
+	} else {
+		t.reset()
+	}
 }
 
 type errorWindow struct {
@@ -135,22 +648,34 @@ func (ew *errorWindow) add(reason string) {
 	ew.lock.Unlock()
 }
 
-func (ew *errorWindow) String() string {
+// list returns the reasons in reverse order, the latest first.
+func (ew *errorWindow) list() []string {
 	var reasons []string
 
 	ew.lock.Lock()
-	// reverse order
 	for i := ew.index - 1; i >= ew.index-ew.count; i-- {
 		reasons = append(reasons, ew.reasons[(i+numHistoryReasons)%numHistoryReasons])
 	}
 	ew.lock.Unlock()
 
-	return strings.Join(reasons, "\n")
+	return reasons
+}
+
+func (ew *errorWindow) reset() {
+	ew.lock.Lock()
+	ew.index = 0
+	ew.count = 0
+	ew.lock.Unlock()
+}
+
+func (ew *errorWindow) String() string {
+	return strings.Join(ew.list(), "\n")
 }
 
 type promiseWithReason struct {
-	promise internalPromise
-	errWin  *errorWindow
+	promise   internalPromise
+	errWin    *errorWindow
+	latencies *latencyRecorder
 }
 
 func (p promiseWithReason) Accept() {
@@ -162,6 +687,77 @@ func (p promiseWithReason) Reject(reason string) {
 	p.promise.Reject()
 }
 
+func (p promiseWithReason) AcceptWithLatency(op string, latency time.Duration) {
+	p.latencies.add(op, latency)
+	if lp, ok := p.promise.(internalLatencyPromise); ok {
+		lp.acceptWithLatency(latency)
+		return
+	}
+
+	p.promise.Accept()
+}
+
+func (p promiseWithReason) RejectWithLatency(op, reason string, latency time.Duration) {
+	p.latencies.add(op, latency)
+	p.errWin.add(reason)
+	if lp, ok := p.promise.(internalLatencyPromise); ok {
+		lp.rejectWithLatency(latency)
+		return
+	}
+
+	p.promise.Reject()
+}
+
+// dryRunPromise is returned for the calls that would have been dropped in dry run,
+// it records nothing as the calls were dropped.
+type dryRunPromise struct{}
+
+func (dryRunPromise) Accept() {}
+
+func (dryRunPromise) Reject(string) {}
+
+func (s State) String() string {
+	switch s {
+	case StateForcedOpen:
+		return "forced-open"
+	case StateForcedClosed:
+		return "forced-closed"
+	default:
+		return "auto"
+	}
+}
+
+// admitRatio returns the ratio of requests to admit at progress in [0, 1] of the ramp.
+func (r Ramp) admitRatio(progress float64) float64 {
+	switch r {
+	case RampExponential:
+		return slowStartFloor * math.Pow(1/slowStartFloor, progress)
+	default:
+		return slowStartFloor + (1-slowStartFloor)*progress
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *State) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "auto":
+		*s = StateAuto
+	case "forced-open":
+		*s = StateForcedOpen
+	case "forced-closed":
+		*s = StateForcedClosed
+	default:
+		return fmt.Errorf("unknown breaker state %q", text)
+	}
+
+	return nil
+}
+
 // MinInt returns the smaller one of a and b.
 func MinInt(a, b int) int {
 	if a < b {
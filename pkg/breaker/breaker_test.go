@@ -1,10 +1,16 @@
 package breaker
 
 import (
+	"database/sql"
 	"fmt"
+	"math/rand"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/chenquan/sqlbreaker/pkg/mathx"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,6 +43,280 @@ func TestCircuitBreaker_Allow(t *testing.T) {
 	})
 }
 
+func TestCircuitBreaker_Force(t *testing.T) {
+	b := NewBreaker(WithName("force")).(Controller)
+
+	b.Force(StateForcedOpen)
+	for i := 0; i < 100; i++ {
+		_, err := b.Allow()
+		assert.ErrorIs(t, err, ErrServiceUnavailable)
+	}
+
+	b.Force(StateForcedClosed)
+	for i := 0; i < 1000; i++ {
+		allow, err := b.Allow()
+		assert.NoError(t, err)
+		allow.Reject("any")
+	}
+	stats := b.(Inspector).Stats()
+	assert.Equal(t, StateForcedClosed, stats.State)
+	assert.Equal(t, int64(1000), stats.Total)
+	assert.True(t, stats.DropRatio > 0)
+
+	b.Force(StateAuto)
+	openBreaker := false
+	for i := 0; i < 100; i++ {
+		if _, err := b.Allow(); err == ErrServiceUnavailable {
+			openBreaker = true
+		}
+	}
+	assert.True(t, openBreaker)
+}
+
+func TestCircuitBreaker_Reset(t *testing.T) {
+	b := NewBreaker(WithName("reset")).(Controller)
+	b.Force(StateForcedClosed)
+	for i := 0; i < 100; i++ {
+		allow, err := b.Allow()
+		assert.NoError(t, err)
+		allow.Reject("any")
+	}
+
+	b.Reset()
+	stats := b.(Inspector).Stats()
+	assert.Equal(t, "reset", stats.Name)
+	assert.Equal(t, StateAuto, stats.State)
+	assert.Equal(t, int64(0), stats.Total)
+	assert.Equal(t, float64(0), stats.DropRatio)
+	assert.Empty(t, stats.Reasons)
+}
+
+func TestCircuitBreaker_RecordWait(t *testing.T) {
+	b := NewBreaker().(*circuitBreaker)
+	b.RecordWait(time.Millisecond*10, false)
+	b.RecordWait(time.Millisecond*20, true)
+	stats := b.Stats()
+	assert.Equal(t, int64(2), stats.WaitTime.Count)
+	assert.Equal(t, time.Millisecond*20, stats.WaitTime.Max)
+	assert.Equal(t, int64(1), stats.WaitTimeouts)
+
+	b.Reset()
+	stats = b.Stats()
+	assert.Equal(t, int64(0), stats.WaitTime.Count)
+	assert.Equal(t, int64(0), stats.WaitTimeouts)
+}
+
+func TestCircuitBreaker_Stats(t *testing.T) {
+	b := NewBreaker(WithName("stats"))
+	for i := 0; i < 10; i++ {
+		allow, err := b.Allow()
+		assert.NoError(t, err)
+		if i%2 == 0 {
+			allow.Accept()
+		} else {
+			allow.Reject("fail")
+		}
+	}
+
+	stats := b.(Inspector).Stats()
+	assert.Equal(t, "stats", stats.Name)
+	assert.Equal(t, int64(5), stats.Accepts)
+	assert.Equal(t, int64(10), stats.Total)
+	assert.Equal(t, float64(k), stats.Params[paramK])
+	assert.Equal(t, float64(protection), stats.Params[paramProtection])
+	assert.Len(t, stats.Reasons, numHistoryReasons)
+	assert.True(t, strings.HasSuffix(stats.Reasons[0], "fail"))
+}
+
+func TestWithDecay(t *testing.T) {
+	b := NewBreaker(WithDecay(time.Second)).(*circuitBreaker)
+	assert.Equal(t, time.Second, b.halfLife)
+	_, ok := b.throttle.(loggedThrottle).internalThrottle.(*googleBreaker).stat.(decayStat)
+	assert.True(t, ok)
+
+	for i := 0; i < 10; i++ {
+		allow, err := b.Allow()
+		assert.NoError(t, err)
+		allow.Accept()
+	}
+	stats := b.Stats()
+	assert.Equal(t, int64(10), stats.Accepts)
+	assert.Equal(t, int64(10), stats.Total)
+
+	b = NewBreaker().(*circuitBreaker)
+	_, ok = b.throttle.(loggedThrottle).internalThrottle.(*googleBreaker).stat.(windowStat)
+	assert.True(t, ok)
+}
+
+func TestWithClock(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := NewBreaker(WithClock(clock)).(*circuitBreaker)
+	for i := 0; i < 100; i++ {
+		allow, err := b.Allow()
+		if err == nil {
+			allow.(LatencyPromise).RejectWithLatency("query", "fail", time.Second)
+		}
+	}
+	stats := b.Stats()
+	assert.True(t, stats.DropRatio > 0)
+	assert.True(t, stats.Latency.Count > 0)
+
+	clock.Advance(window)
+	stats = b.Stats()
+	assert.Equal(t, int64(0), stats.Total)
+	assert.Equal(t, float64(0), stats.DropRatio)
+	assert.Equal(t, int64(0), stats.Latency.Count)
+
+	lb := NewLatencyBreaker(time.Millisecond, 0.99, WithClock(clock)).(*circuitBreaker)
+	assert.Equal(t, clock, lb.clock)
+	assert.Equal(t, timex.RealClock(), NewBreaker().(*circuitBreaker).clock)
+}
+
+func TestWithSeed(t *testing.T) {
+	run := func(opts ...Option) []bool {
+		b := NewBreaker(append(opts, WithClock(timex.NewManualClock(0)))...)
+		var drops []bool
+		for i := 0; i < 200; i++ {
+			allow, err := b.Allow()
+			drops = append(drops, err != nil)
+			if err == nil {
+				allow.Reject("fail")
+			}
+		}
+		return drops
+	}
+
+	drops := run(WithSeed(1))
+	assert.Contains(t, drops, true)
+	assert.Equal(t, drops, run(WithSeed(1)))
+	assert.Equal(t, drops, run(WithRandSource(rand.NewSource(1))))
+	assert.NotEqual(t, drops, run(WithSeed(2)))
+}
+
+func TestWithSeed_Concurrent(t *testing.T) {
+	pool := new(mockedPool)
+	pool.set(func(stats *sql.DBStats) {
+		stats.MaxOpenConnections = 10
+		stats.InUse = 10
+	})
+	// the throttles of a breaker, and the breakers given the same option, draw from the source concurrently.
+	seed := WithSeed(1)
+	src := WithRandSource(rand.NewSource(1))
+	breakers := []Breaker{
+		NewBreaker(WithPoolLimit(pool.Stats, 0, 0.5), seed),
+		NewBreaker(WithPoolLimit(pool.Stats, 0, 0.5), seed),
+		NewBreaker(WithPoolLimit(pool.Stats, 0, 0.5), src),
+		NewBreaker(WithPoolLimit(pool.Stats, 0, 0.5), src),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(b Breaker) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if p, err := b.Allow(); err == nil {
+					p.Reject("fail")
+				}
+			}
+		}(breakers[i%len(breakers)])
+	}
+	wg.Wait()
+}
+
+func TestWithErrorDiffusion(t *testing.T) {
+	b := NewBreaker(WithErrorDiffusion()).(*circuitBreaker)
+	_, ok := b.throttle.(loggedThrottle).internalThrottle.(*googleBreaker).proba.(*mathx.Diffusion)
+	assert.True(t, ok)
+
+	lb := NewLatencyBreaker(time.Second, 0.99, WithErrorDiffusion()).(*circuitBreaker)
+	_, ok = lb.throttle.(loggedThrottle).internalThrottle.(*latencyBreaker).proba.(*mathx.Diffusion)
+	assert.True(t, ok)
+}
+
+func TestWithDryRun(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	dry := NewBreaker(WithDryRun(), WithSeed(1), WithClock(clock)).(*circuitBreaker)
+	enforced := NewBreaker(WithSeed(1), WithClock(clock)).(*circuitBreaker)
+
+	var drops int64
+	for i := 0; i < 1000; i++ {
+		allow, err := dry.Allow()
+		assert.NoError(t, err)
+		allow.Reject("fail")
+
+		allow, err = enforced.Allow()
+		if err != nil {
+			drops++
+			continue
+		}
+		allow.Reject("fail")
+	}
+
+	stats := dry.Stats()
+	assert.True(t, stats.DryRun)
+	assert.True(t, stats.WouldDrop > 0)
+	// behaves the same as the enforced one.
+	assert.Equal(t, drops, stats.WouldDrop)
+	assert.Equal(t, enforced.Stats().Total, stats.Total)
+	assert.Contains(t, stats.Reasons[0], "dry run: would have dropped")
+	assert.False(t, enforced.Stats().DryRun)
+
+	dry.Force(StateForcedOpen)
+	_, err := dry.Allow()
+	assert.ErrorIs(t, err, ErrServiceUnavailable)
+
+	dry.Reset()
+	assert.Equal(t, int64(0), dry.Stats().WouldDrop)
+}
+
+func TestWithMaxDropRatio(t *testing.T) {
+	assert.Panics(t, func() {
+		WithMaxDropRatio(1.5)
+	})
+
+	b := NewBreaker(WithMaxDropRatio(0.9)).(*circuitBreaker)
+	assert.Equal(t, 0.9, b.Stats().Params[paramMaxDrop])
+}
+
+func TestWithProbeInterval(t *testing.T) {
+	assert.Panics(t, func() {
+		WithProbeInterval(-time.Second)
+	})
+
+	b := NewBreaker(WithProbeInterval(time.Second)).(*circuitBreaker)
+	assert.Equal(t, float64(1), b.Stats().Params[paramProbe])
+	_, ok := b.Stats().Params[paramMaxDrop]
+	assert.False(t, ok)
+}
+
+func TestCircuitBreaker_Tune(t *testing.T) {
+	b := NewBreaker().(Controller)
+	assert.NoError(t, b.Tune(paramK, 2))
+	assert.NoError(t, b.Tune(paramProtection, 10))
+	assert.ErrorIs(t, b.Tune(paramK, 0.5), ErrInvalidParam)
+	assert.ErrorIs(t, b.Tune(paramProtection, -1), ErrInvalidParam)
+	assert.ErrorIs(t, b.Tune("any", 1), ErrUnknownParam)
+
+	params := b.(Inspector).Stats().Params
+	assert.Equal(t, float64(2), params[paramK])
+	assert.Equal(t, float64(10), params[paramProtection])
+}
+
+func TestState(t *testing.T) {
+	for _, state := range []State{StateAuto, StateForcedOpen, StateForcedClosed} {
+		text, err := state.MarshalText()
+		assert.NoError(t, err)
+
+		var actual State
+		assert.NoError(t, actual.UnmarshalText(text))
+		assert.Equal(t, state, actual)
+	}
+
+	var state State
+	assert.Error(t, state.UnmarshalText([]byte("any")))
+}
+
 func TestErrorWindow(t *testing.T) {
 	tests := []struct {
 		name    string
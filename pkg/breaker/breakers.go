@@ -0,0 +1,70 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	lock     sync.RWMutex
+	breakers = make(map[string]Breaker)
+)
+
+// Register registers b by its name, so that it can be found by Lookup and listed by Breakers.
+// A Breaker registered with the same name is replaced.
+func Register(b Breaker) {
+	lock.Lock()
+	breakers[b.Name()] = b
+	lock.Unlock()
+}
+
+// Unregister removes the Breaker registered with name.
+func Unregister(name string) {
+	lock.Lock()
+	delete(breakers, name)
+	lock.Unlock()
+}
+
+// Lookup returns the Breaker registered with name.
+func Lookup(name string) (Breaker, bool) {
+	lock.RLock()
+	b, ok := breakers[name]
+	lock.RUnlock()
+
+	return b, ok
+}
+
+// GetBreaker returns the Breaker registered with name,
+// a new Breaker will be created and registered if not exists.
+func GetBreaker(name string) Breaker {
+	if b, ok := Lookup(name); ok {
+		return b
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	b, ok := breakers[name]
+	if !ok {
+		b = NewBreaker(WithName(name))
+		breakers[name] = b
+	}
+
+	return b
+}
+
+// Breakers returns all registered Breakers sorted by name.
+func Breakers() []Breaker {
+	lock.RLock()
+	list := make([]Breaker, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, b)
+	}
+	lock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+
+	return list
+}
@@ -0,0 +1,47 @@
+package breaker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	b := NewBreaker(WithName("register"))
+	Register(b)
+	defer Unregister(b.Name())
+
+	actual, ok := Lookup("register")
+	assert.True(t, ok)
+	assert.True(t, b == actual)
+
+	Unregister("register")
+	_, ok = Lookup("register")
+	assert.False(t, ok)
+}
+
+func TestGetBreaker(t *testing.T) {
+	defer Unregister("get")
+
+	b := GetBreaker("get")
+	assert.Equal(t, "get", b.Name())
+	assert.True(t, b == GetBreaker("get"))
+
+	actual, ok := Lookup("get")
+	assert.True(t, ok)
+	assert.True(t, b == actual)
+}
+
+func TestBreakers(t *testing.T) {
+	names := []string{"c", "a", "b"}
+	for _, name := range names {
+		Register(NewBreaker(WithName(name)))
+		defer Unregister(name)
+	}
+
+	var actual []string
+	for _, b := range Breakers() {
+		actual = append(actual, b.Name())
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, actual)
+}
@@ -0,0 +1,242 @@
+package breaker
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+const (
+	paramLimit   = "limit"
+	paramMaxWait = "maxWait"
+)
+
+// ErrBulkheadFull is returned when a bulkhead has no room for the call in time.
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// bulkhead caps the number of calls in flight, the calls over the cap wait up to maxWait in FIFO order.
+type bulkhead struct {
+	// lock guards all below but stat and queue.
+	lock     sync.Mutex
+	limit    int64
+	maxWait  time.Duration
+	inFlight int64
+	waiters  list.List // of chan struct{}
+
+	clock timex.Clock
+	// admitted calls are counted as 1, the calls that are not as 0.
+	stat windowStat
+	// queue times in seconds
+	queue *collection.RollingHistogram
+}
+
+// NewBulkhead returns a Breaker that allows at most limit calls in flight,
+// a call is in flight until its promise is accepted or rejected.
+// The calls over limit wait up to maxWait for a slot before failing with ErrBulkheadFull,
+// a maxWait of 0 fails them at once. Use WithBulkhead to put a bulkhead in front of another Breaker.
+func NewBulkhead(limit int, maxWait time.Duration, opts ...Option) Breaker {
+	checkBulkhead(limit, maxWait)
+
+	return newCircuitBreaker(func(opts throttleOptions) internalThrottle {
+		return newBulkhead(limit, maxWait, opts.clock)
+	}, opts...)
+}
+
+// WithBulkhead returns a function to put a bulkhead in front of a Breaker, see NewBulkhead.
+// The calls admitted by the bulkhead are then checked by the Breaker.
+func WithBulkhead(limit int, maxWait time.Duration) Option {
+	checkBulkhead(limit, maxWait)
+
+	return func(b *circuitBreaker) {
+		b.front = append(b.front, func(opts throttleOptions) internalThrottle {
+			return newBulkhead(limit, maxWait, opts.clock)
+		})
+	}
+}
+
+func checkBulkhead(limit int, maxWait time.Duration) {
+	if limit < 1 {
+		panic("limit must be greater than 0")
+	}
+	if maxWait < 0 {
+		panic("maxWait must not be negative")
+	}
+}
+
+func newBulkhead(limit int, maxWait time.Duration, clock timex.Clock) *bulkhead {
+	bucketDuration := time.Duration(int64(window) / int64(buckets))
+	return &bulkhead{
+		limit:   int64(limit),
+		maxWait: maxWait,
+		clock:   clock,
+		stat: windowStat{collection.NewAtomicRollingWindow(buckets, bucketDuration,
+			collection.WithAtomicRollingWindowClock(clock))},
+		queue: collection.NewRollingHistogram(buckets, bucketDuration, collection.WithRollingHistogramClock(clock)),
+	}
+}
+
+func (b *bulkhead) allow() (internalPromise, error) {
+	return b.allowWithin("", unbounded)
+}
+
+// allowWithin waits no longer than maxWait for a slot, on top of the maxWait of the bulkhead.
+func (b *bulkhead) allowWithin(_ string, maxWait time.Duration) (internalPromise, error) {
+	start := b.clock.Now()
+	if !b.acquire(maxWait) {
+		b.stat.add(0)
+		return nil, ErrBulkheadFull
+	}
+
+	b.stat.add(1)
+	b.queue.Add(b.clock.Since(start).Seconds())
+	return bulkheadPromise{b: b}, nil
+}
+
+// promise takes a slot even if there is no room.
+func (b *bulkhead) promise() internalPromise {
+	b.lock.Lock()
+	b.inFlight++
+	b.lock.Unlock()
+
+	return bulkheadPromise{b: b}
+}
+
+// acquire takes a slot, waiting up to the maxWait of the bulkhead or bound if sooner.
+// The waits are timed by the real time, as the clock can't wake the waiters.
+func (b *bulkhead) acquire(bound time.Duration) bool {
+	b.lock.Lock()
+	if b.inFlight < b.limit && b.waiters.Len() == 0 {
+		b.inFlight++
+		b.lock.Unlock()
+		return true
+	}
+
+	maxWait := b.maxWait
+	if bound < maxWait {
+		maxWait = bound
+	}
+	if maxWait <= 0 {
+		b.lock.Unlock()
+		return false
+	}
+
+	ready := make(chan struct{})
+	elem := b.waiters.PushBack(ready)
+	b.lock.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		select {
+		case <-ready:
+			// acquired right before timing out.
+			return true
+		default:
+			b.waiters.Remove(elem)
+			return false
+		}
+	}
+}
+
+func (b *bulkhead) release() {
+	b.lock.Lock()
+	b.inFlight--
+	b.notify()
+	b.lock.Unlock()
+}
+
+// notify hands the free slots over to the waiters, b.lock must be held.
+func (b *bulkhead) notify() {
+	for b.inFlight < b.limit && b.waiters.Len() > 0 {
+		ready := b.waiters.Remove(b.waiters.Front()).(chan struct{})
+		b.inFlight++
+		close(ready)
+	}
+}
+
+func (b *bulkhead) stats() Stats {
+	accepts, total := b.stat.history()
+	queue := b.queue.Snapshot()
+
+	b.lock.Lock()
+	inFlight := b.inFlight
+	params := map[string]float64{
+		paramLimit:   float64(b.limit),
+		paramMaxWait: b.maxWait.Seconds(),
+	}
+	b.lock.Unlock()
+
+	var dropRatio float64
+	if total > 0 {
+		dropRatio = 1 - accepts/total
+	}
+
+	return Stats{
+		Accepts:   int64(accepts),
+		Total:     int64(total),
+		DropRatio: dropRatio,
+		Params:    params,
+		InFlight:  inFlight,
+		QueueTime: newLatencyStats(&queue),
+	}
+}
+
+// reset clears the statistics, the calls in flight are kept.
+func (b *bulkhead) reset() {
+	b.stat.reset()
+	b.queue.Reset()
+}
+
+func (b *bulkhead) tune(param string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return ErrInvalidParam
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch param {
+	case paramLimit:
+		if value < 1 {
+			return ErrInvalidParam
+		}
+		b.limit = int64(value)
+		b.notify()
+	case paramMaxWait:
+		b.maxWait = time.Duration(value * float64(time.Second))
+	default:
+		return ErrUnknownParam
+	}
+
+	return nil
+}
+
+type bulkheadPromise struct {
+	b *bulkhead
+}
+
+func (p bulkheadPromise) Accept() {
+	p.b.release()
+}
+
+func (p bulkheadPromise) Reject() {
+	p.b.release()
+}
+
+func (p bulkheadPromise) stateless() {}
+
+// cancel gives the slot back if the call is dropped by a throttle behind.
+func (p bulkheadPromise) cancel() {
+	p.b.release()
+}
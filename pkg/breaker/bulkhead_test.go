@@ -0,0 +1,180 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBulkhead(t *testing.T) {
+	assert.Panics(t, func() {
+		NewBulkhead(0, 0)
+	})
+	assert.Panics(t, func() {
+		NewBulkhead(1, -1)
+	})
+	assert.Panics(t, func() {
+		WithBulkhead(0, 0)
+	})
+
+	b := NewBulkhead(1, 0, WithName("bulkhead"))
+	assert.Equal(t, "bulkhead", b.Name())
+}
+
+func TestBulkhead_Allow(t *testing.T) {
+	b := newBulkhead(2, 0, timex.NewManualClock(0))
+	p1, err := b.allow()
+	assert.NoError(t, err)
+	p2, err := b.allow()
+	assert.NoError(t, err)
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+	assert.Equal(t, int64(2), b.stats().InFlight)
+
+	p1.Accept()
+	p3, err := b.allow()
+	assert.NoError(t, err)
+	p2.Reject()
+	p3.Accept()
+
+	stats := b.stats()
+	assert.Equal(t, int64(0), stats.InFlight)
+	assert.Equal(t, int64(3), stats.Accepts)
+	assert.Equal(t, int64(4), stats.Total)
+	assert.Equal(t, 0.25, stats.DropRatio)
+	assert.Equal(t, float64(2), stats.Params[paramLimit])
+	assert.Equal(t, float64(0), stats.Params[paramMaxWait])
+	assert.Equal(t, int64(3), stats.QueueTime.Count)
+
+	b.reset()
+	assert.Equal(t, int64(0), b.stats().Total)
+}
+
+func TestBulkhead_Wait(t *testing.T) {
+	t.Run("acquired", func(t *testing.T) {
+		b := newBulkhead(1, time.Second, timex.RealClock())
+		p, err := b.allow()
+		assert.NoError(t, err)
+
+		go func() {
+			time.Sleep(time.Millisecond * 10)
+			p.Accept()
+		}()
+
+		p, err = b.allow()
+		assert.NoError(t, err)
+		stats := b.stats()
+		assert.Equal(t, int64(1), stats.InFlight)
+		assert.True(t, stats.QueueTime.Max >= time.Millisecond*10)
+		p.Accept()
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		b := newBulkhead(1, time.Millisecond*10, timex.RealClock())
+		_, err := b.allow()
+		assert.NoError(t, err)
+
+		start := time.Now()
+		_, err = b.allow()
+		assert.ErrorIs(t, err, ErrBulkheadFull)
+		assert.True(t, time.Since(start) >= time.Millisecond*10)
+		assert.Equal(t, 0, b.waiters.Len())
+		assert.Equal(t, int64(1), b.stats().InFlight)
+	})
+
+	t.Run("fifo", func(t *testing.T) {
+		b := newBulkhead(1, time.Second, timex.RealClock())
+		p, err := b.allow()
+		assert.NoError(t, err)
+
+		order := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			i := i
+			go func() {
+				p, err := b.allow()
+				assert.NoError(t, err)
+				order <- i
+				p.Accept()
+			}()
+			// wait until queued
+			for {
+				b.lock.Lock()
+				n := b.waiters.Len()
+				b.lock.Unlock()
+				if n == i+1 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		p.Accept()
+		assert.Equal(t, 0, <-order)
+		assert.Equal(t, 1, <-order)
+	})
+
+	t.Run("tune limit", func(t *testing.T) {
+		b := newBulkhead(1, time.Second, timex.RealClock())
+		_, err := b.allow()
+		assert.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			_, err := b.allow()
+			done <- err
+		}()
+		for {
+			b.lock.Lock()
+			n := b.waiters.Len()
+			b.lock.Unlock()
+			if n == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		assert.NoError(t, b.tune(paramLimit, 2))
+		assert.NoError(t, <-done)
+		assert.Equal(t, int64(2), b.stats().InFlight)
+	})
+}
+
+func TestBulkhead_AllowWithin(t *testing.T) {
+	b := NewBreaker(WithBulkhead(1, time.Hour)).(BoundedBreaker)
+	p, err := b.AllowWithin("", time.Millisecond)
+	assert.NoError(t, err)
+
+	// the wait is capped at the bound rather than the max wait of the bulkhead.
+	start := time.Now()
+	_, err = b.AllowWithin("", time.Millisecond*10)
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+	assert.True(t, time.Since(start) < time.Second)
+	_, err = b.AllowWithin("", 0)
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+	p.Accept()
+}
+
+func TestBulkhead_Tune(t *testing.T) {
+	b := newBulkhead(1, 0, timex.NewManualClock(0))
+	assert.NoError(t, b.tune(paramMaxWait, 0.5))
+	assert.ErrorIs(t, b.tune(paramLimit, 0), ErrInvalidParam)
+	assert.ErrorIs(t, b.tune(paramMaxWait, -1), ErrInvalidParam)
+	assert.ErrorIs(t, b.tune("any", 1), ErrUnknownParam)
+	assert.Equal(t, time.Millisecond*500, b.maxWait)
+}
+
+func TestBulkhead_ForcedClosed(t *testing.T) {
+	b := NewBulkhead(1, 0).(*circuitBreaker)
+	b.Force(StateForcedClosed)
+	p1, err := b.Allow()
+	assert.NoError(t, err)
+	p2, err := b.Allow()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), b.Stats().InFlight)
+
+	p1.Accept()
+	p2.Reject("any")
+	assert.Equal(t, int64(0), b.Stats().InFlight)
+}
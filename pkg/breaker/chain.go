@@ -0,0 +1,205 @@
+package breaker
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+type (
+	// chainThrottle lets a call through only if all throttles allow it, in order.
+	// The last one is the main throttle, its params take precedence in stats and tune.
+	chainThrottle struct {
+		throttles []internalThrottle
+		// shared holds the *statelessChainPromise if all promises are stateless.
+		shared *atomic.Value
+	}
+
+	// cancelablePromise is implemented by the internal promises that hold resources,
+	// which must be given back if the call is dropped by a throttle behind.
+	cancelablePromise interface {
+		internalPromise
+		cancel()
+	}
+
+	chainPromise struct {
+		promises []internalPromise
+	}
+
+	statelessChainPromise struct {
+		chainPromise
+	}
+)
+
+// maxChainLen is the length of chains that allow calls without allocations.
+const maxChainLen = 4
+
+func newChainThrottle(throttles ...internalThrottle) *chainThrottle {
+	return &chainThrottle{
+		throttles: throttles,
+		shared:    new(atomic.Value),
+	}
+}
+
+func (c *chainThrottle) allow() (internalPromise, error) {
+	return c.allowKey("")
+}
+
+// allowKey passes key to the throttles that have limits by key, an empty key means none.
+func (c *chainThrottle) allowKey(key string) (internalPromise, error) {
+	return c.allowWithin(key, unbounded)
+}
+
+// allowWithin is like allowKey, but bounds the waits of the throttles by maxWait in total.
+func (c *chainThrottle) allowWithin(key string, maxWait time.Duration) (internalPromise, error) {
+	var buf [maxChainLen]internalPromise
+	promises := buf[:0]
+	var start time.Duration
+	if maxWait != unbounded {
+		start = timex.Now()
+	}
+	for _, t := range c.throttles {
+		var p internalPromise
+		var err error
+		if bt, ok := t.(boundedThrottle); ok && maxWait != unbounded {
+			p, err = bt.allowWithin(key, maxWait-timex.Since(start))
+		} else if kt, ok := t.(keyedThrottle); ok && len(key) > 0 {
+			p, err = kt.allowKey(key)
+		} else {
+			p, err = t.allow()
+		}
+		if err != nil {
+			for _, prev := range promises {
+				if cp, ok := prev.(cancelablePromise); ok {
+					cp.cancel()
+				}
+			}
+			return nil, err
+		}
+		promises = append(promises, p)
+	}
+
+	return c.wrap(promises), nil
+}
+
+func (c *chainThrottle) promise() internalPromise {
+	var buf [maxChainLen]internalPromise
+	promises := buf[:0]
+	for _, t := range c.throttles {
+		promises = append(promises, t.promise())
+	}
+
+	return c.wrap(promises)
+}
+
+func (c *chainThrottle) wrap(promises []internalPromise) internalPromise {
+	stateless := true
+	for _, p := range promises {
+		if _, ok := p.(statelessPromise); !ok {
+			stateless = false
+			break
+		}
+	}
+
+	if stateless {
+		// a pointer is returned to save the allocation of boxing.
+		if shared, ok := c.shared.Load().(*statelessChainPromise); ok {
+			return shared
+		}
+	}
+
+	p := chainPromise{promises: append([]internalPromise(nil), promises...)}
+	if stateless {
+		shared := &statelessChainPromise{chainPromise: p}
+		c.shared.Store(shared)
+		return shared
+	}
+
+	return p
+}
+
+func (c *chainThrottle) stats() Stats {
+	last := len(c.throttles) - 1
+	stats := c.throttles[last].stats()
+	pass := 1 - stats.DropRatio
+	for _, t := range c.throttles[:last] {
+		s := t.stats()
+		pass *= 1 - s.DropRatio
+		for param, value := range s.Params {
+			if stats.Params == nil {
+				stats.Params = make(map[string]float64)
+			}
+			if _, ok := stats.Params[param]; !ok {
+				stats.Params[param] = value
+			}
+		}
+		stats.InFlight += s.InFlight
+		stats.Overloaded = stats.Overloaded || s.Overloaded
+		if s.QueueTime.Count > 0 {
+			stats.QueueTime = s.QueueTime
+		}
+	}
+	stats.DropRatio = 1 - pass
+
+	return stats
+}
+
+func (c *chainThrottle) reset() {
+	for _, t := range c.throttles {
+		t.reset()
+	}
+}
+
+func (c *chainThrottle) recover() {
+	for _, t := range c.throttles {
+		recoverThrottle(t)
+	}
+}
+
+func (c *chainThrottle) tune(param string, value float64) error {
+	for i := len(c.throttles) - 1; i >= 0; i-- {
+		if err := c.throttles[i].tune(param, value); !errors.Is(err, ErrUnknownParam) {
+			return err
+		}
+	}
+
+	return ErrUnknownParam
+}
+
+// Accept accepts the promises from the main throttle to the front.
+func (p chainPromise) Accept() {
+	for i := len(p.promises) - 1; i >= 0; i-- {
+		p.promises[i].Accept()
+	}
+}
+
+// Reject rejects the promises from the main throttle to the front.
+func (p chainPromise) Reject() {
+	for i := len(p.promises) - 1; i >= 0; i-- {
+		p.promises[i].Reject()
+	}
+}
+
+func (p chainPromise) acceptWithLatency(latency time.Duration) {
+	for i := len(p.promises) - 1; i >= 0; i-- {
+		if lp, ok := p.promises[i].(internalLatencyPromise); ok {
+			lp.acceptWithLatency(latency)
+		} else {
+			p.promises[i].Accept()
+		}
+	}
+}
+
+func (p chainPromise) rejectWithLatency(latency time.Duration) {
+	for i := len(p.promises) - 1; i >= 0; i-- {
+		if lp, ok := p.promises[i].(internalLatencyPromise); ok {
+			lp.rejectWithLatency(latency)
+		} else {
+			p.promises[i].Reject()
+		}
+	}
+}
+
+func (p statelessChainPromise) stateless() {}
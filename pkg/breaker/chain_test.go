@@ -0,0 +1,81 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainThrottle(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := NewBreaker(WithBulkhead(2, 0), WithClock(clock), WithSeed(1)).(*circuitBreaker)
+
+	p1, err := b.Allow()
+	assert.NoError(t, err)
+	p2, err := b.Allow()
+	assert.NoError(t, err)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+
+	stats := b.Stats()
+	assert.Equal(t, int64(2), stats.InFlight)
+	assert.Equal(t, float64(2), stats.Params[paramLimit])
+	assert.Equal(t, float64(k), stats.Params[paramK])
+	assert.InDelta(t, 1.0/3, stats.DropRatio, 1e-9)
+
+	p1.(LatencyPromise).AcceptWithLatency("query", time.Millisecond)
+	p2.Reject("fail")
+	stats = b.Stats()
+	assert.Equal(t, int64(0), stats.InFlight)
+	// the google breaker counts the outcomes
+	assert.Equal(t, int64(1), stats.Accepts)
+	assert.Equal(t, int64(2), stats.Total)
+
+	assert.NoError(t, b.Tune(paramLimit, 3))
+	assert.NoError(t, b.Tune(paramK, 2))
+	assert.ErrorIs(t, b.Tune("any", 1), ErrUnknownParam)
+	assert.ErrorIs(t, b.Tune(paramLimit, 0), ErrInvalidParam)
+
+	b.Reset()
+	assert.Equal(t, float64(0), b.Stats().DropRatio)
+}
+
+func TestChainThrottle_Cancel(t *testing.T) {
+	b := NewBreaker(WithBulkhead(1, 0), WithErrorDiffusion(), WithClock(timex.NewManualClock(0))).(*circuitBreaker)
+	for i := 0; i < 100; i++ {
+		p, err := b.Allow()
+		if err == nil {
+			p.Reject("fail")
+		}
+	}
+
+	// the slots taken by the calls that the google breaker dropped are given back.
+	assert.Equal(t, int64(0), b.Stats().InFlight)
+	assert.True(t, b.Stats().Total < 100)
+}
+
+func TestChainThrottle_ForcedClosed(t *testing.T) {
+	b := NewBreaker(WithBulkhead(1, 0)).(*circuitBreaker)
+	b.Force(StateForcedClosed)
+	p1, err := b.Allow()
+	assert.NoError(t, err)
+	p2, err := b.Allow()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), b.Stats().InFlight)
+	p1.Accept()
+	p2.Accept()
+	assert.Equal(t, int64(0), b.Stats().InFlight)
+}
+
+func TestChainThrottle_Allocs(t *testing.T) {
+	b := NewBreaker(WithRateLimit(1e9, 1e6), WithBulkhead(10, 0))
+	allocs := testing.AllocsPerRun(100, func() {
+		p, err := b.Allow()
+		if err == nil {
+			p.Accept()
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+}
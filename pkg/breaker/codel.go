@@ -0,0 +1,284 @@
+package breaker
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+const (
+	paramTarget   = "target"
+	paramInterval = "interval"
+)
+
+// ErrQueueTimeout is returned when a call waits in the queue of a CoDel breaker for too long.
+var ErrQueueTimeout = errors.New("queue delay is too long")
+
+type (
+	// codel caps the calls in flight like a bulkhead, and controls the delay of the calls queued over the cap:
+	// once the delay of all calls stays above target for interval, it's overloaded and serves the queue in LIFO order,
+	// the calls queued for longer than target are dropped, until the queue drains.
+	// Otherwise, it serves the queue in FIFO order, and the calls queued for longer than interval are dropped.
+	codel struct {
+		// lock guards all below but stat and queue.
+		lock     sync.Mutex
+		limit    int64
+		target   time.Duration
+		interval time.Duration
+		inFlight int64
+		waiters  list.List // of *codelWaiter
+		// aboveSince is when the delay went above target, or -1 if it's below.
+		aboveSince time.Duration
+		overloaded bool
+
+		clock timex.Clock
+		// admitted calls are counted as 1, the calls that are not as 0.
+		stat windowStat
+		// queue times in seconds
+		queue *collection.RollingHistogram
+	}
+
+	codelWaiter struct {
+		ready    chan struct{}
+		enqueued time.Duration
+	}
+
+	codelPromise struct {
+		c *codel
+	}
+)
+
+// NewCoDel returns a Breaker that allows at most limit calls in flight and queues the calls over limit,
+// it sheds the calls by their delay in the queue like CoDel, the controlled delay algorithm.
+// The calls wait up to interval in FIFO order, but once the delay of all calls stays above target for interval,
+// the newest calls are served first and the ones queued for longer than target fail with ErrQueueTimeout,
+// until the queue drains. Typical values are 5ms for target and 100ms for interval.
+func NewCoDel(limit int, target, interval time.Duration, opts ...Option) Breaker {
+	if limit < 1 {
+		panic("limit must be greater than 0")
+	}
+	if target <= 0 || interval < target {
+		panic("target must be greater than 0 and not greater than interval")
+	}
+
+	return newCircuitBreaker(func(opts throttleOptions) internalThrottle {
+		return newCoDel(limit, target, interval, opts.clock)
+	}, opts...)
+}
+
+func newCoDel(limit int, target, interval time.Duration, clock timex.Clock) *codel {
+	bucketDuration := time.Duration(int64(window) / int64(buckets))
+	return &codel{
+		limit:      int64(limit),
+		target:     target,
+		interval:   interval,
+		aboveSince: -1,
+		clock:      clock,
+		stat: windowStat{collection.NewAtomicRollingWindow(buckets, bucketDuration,
+			collection.WithAtomicRollingWindowClock(clock))},
+		queue: collection.NewRollingHistogram(buckets, bucketDuration, collection.WithRollingHistogramClock(clock)),
+	}
+}
+
+func (c *codel) allow() (internalPromise, error) {
+	return c.allowWithin("", unbounded)
+}
+
+// allowWithin queues no longer than maxWait, on top of the timeouts of the queue.
+func (c *codel) allowWithin(_ string, maxWait time.Duration) (internalPromise, error) {
+	delay, ok := c.acquire(maxWait)
+	if !ok {
+		c.stat.add(0)
+		return nil, ErrQueueTimeout
+	}
+
+	c.stat.add(1)
+	c.queue.Add(delay.Seconds())
+	return codelPromise{c: c}, nil
+}
+
+// promise takes a slot even if there is no room.
+func (c *codel) promise() internalPromise {
+	c.lock.Lock()
+	c.inFlight++
+	c.lock.Unlock()
+
+	return codelPromise{c: c}
+}
+
+// acquire takes a slot, waiting in the queue up to its timeout or bound if sooner if there is no room,
+// and returns how long it waited. The waits are timed by the real time, as the clock can't wake the waiters.
+func (c *codel) acquire(bound time.Duration) (time.Duration, bool) {
+	c.lock.Lock()
+	now := c.clock.Now()
+	if c.inFlight < c.limit && c.waiters.Len() == 0 {
+		c.inFlight++
+		c.observe(now, 0)
+		c.lock.Unlock()
+		return 0, true
+	}
+
+	timeout := c.interval
+	if c.overloaded {
+		timeout = c.target
+	}
+	if bound < timeout {
+		timeout = bound
+	}
+	if timeout <= 0 {
+		c.lock.Unlock()
+		return 0, false
+	}
+	waiter := &codelWaiter{ready: make(chan struct{}), enqueued: now}
+	elem := c.waiters.PushBack(waiter)
+	c.lock.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-waiter.ready:
+		return c.clock.Since(now), true
+	case <-timer.C:
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		select {
+		case <-waiter.ready:
+			// acquired right before timing out.
+			return c.clock.Since(now), true
+		default:
+			c.waiters.Remove(elem)
+			c.observe(c.clock.Now(), c.clock.Since(now))
+			return 0, false
+		}
+	}
+}
+
+func (c *codel) release() {
+	c.lock.Lock()
+	c.inFlight--
+	c.notify()
+	c.lock.Unlock()
+}
+
+// notify hands the free slots over to the waiters, the newest first if overloaded, c.lock must be held.
+func (c *codel) notify() {
+	for c.inFlight < c.limit && c.waiters.Len() > 0 {
+		elem := c.waiters.Front()
+		if c.overloaded {
+			elem = c.waiters.Back()
+		}
+		waiter := c.waiters.Remove(elem).(*codelWaiter)
+		c.inFlight++
+		now := c.clock.Now()
+		c.observe(now, now-waiter.enqueued)
+		close(waiter.ready)
+	}
+}
+
+// observe takes the queue delay of a call into account, c.lock must be held.
+func (c *codel) observe(now, delay time.Duration) {
+	if delay < c.target {
+		c.aboveSince = -1
+		// in LIFO order the newest calls are served quickly even under load, only an empty queue tells it's over.
+		if c.waiters.Len() == 0 {
+			c.overloaded = false
+		}
+		return
+	}
+
+	if c.aboveSince < 0 {
+		c.aboveSince = now
+	} else if now-c.aboveSince >= c.interval {
+		c.overloaded = true
+	}
+}
+
+func (c *codel) stats() Stats {
+	accepts, total := c.stat.history()
+	queue := c.queue.Snapshot()
+
+	c.lock.Lock()
+	inFlight := c.inFlight
+	overloaded := c.overloaded
+	params := map[string]float64{
+		paramLimit:    float64(c.limit),
+		paramTarget:   c.target.Seconds(),
+		paramInterval: c.interval.Seconds(),
+	}
+	c.lock.Unlock()
+
+	var dropRatio float64
+	if total > 0 {
+		dropRatio = 1 - accepts/total
+	}
+
+	return Stats{
+		Accepts:    int64(accepts),
+		Total:      int64(total),
+		DropRatio:  dropRatio,
+		Params:     params,
+		InFlight:   inFlight,
+		QueueTime:  newLatencyStats(&queue),
+		Overloaded: overloaded,
+	}
+}
+
+// reset clears the statistics, the calls in flight and the state of overload are kept.
+func (c *codel) reset() {
+	c.stat.reset()
+	c.queue.Reset()
+}
+
+func (c *codel) tune(param string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		return ErrInvalidParam
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	duration := time.Duration(value * float64(time.Second))
+	switch param {
+	case paramLimit:
+		if value < 1 {
+			return ErrInvalidParam
+		}
+		c.limit = int64(value)
+		c.notify()
+	case paramTarget:
+		if duration > c.interval {
+			return ErrInvalidParam
+		}
+		c.target = duration
+	case paramInterval:
+		if duration < c.target {
+			return ErrInvalidParam
+		}
+		c.interval = duration
+	default:
+		return ErrUnknownParam
+	}
+
+	return nil
+}
+
+func (p codelPromise) Accept() {
+	p.c.release()
+}
+
+func (p codelPromise) Reject() {
+	p.c.release()
+}
+
+func (p codelPromise) stateless() {}
+
+// cancel gives the slot back if the call is dropped by a throttle behind.
+func (p codelPromise) cancel() {
+	p.c.release()
+}
@@ -0,0 +1,164 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCoDel(t *testing.T) {
+	assert.Panics(t, func() {
+		NewCoDel(0, time.Millisecond, time.Second)
+	})
+	assert.Panics(t, func() {
+		NewCoDel(1, 0, time.Second)
+	})
+	assert.Panics(t, func() {
+		NewCoDel(1, time.Second, time.Millisecond)
+	})
+
+	b := NewCoDel(1, time.Millisecond*5, time.Millisecond*100, WithName("codel"))
+	assert.Equal(t, "codel", b.Name())
+}
+
+func TestCoDel_Allow(t *testing.T) {
+	c := newCoDel(1, time.Millisecond*5, time.Millisecond*20, timex.RealClock())
+	p, err := c.allow()
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = c.allow()
+	assert.ErrorIs(t, err, ErrQueueTimeout)
+	assert.True(t, time.Since(start) >= time.Millisecond*20)
+	assert.Equal(t, 0, c.waiters.Len())
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		p.Accept()
+	}()
+	p, err = c.allow()
+	assert.NoError(t, err)
+	p.Reject()
+
+	stats := c.stats()
+	assert.Equal(t, int64(0), stats.InFlight)
+	assert.Equal(t, int64(2), stats.Accepts)
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, int64(2), stats.QueueTime.Count)
+	assert.True(t, stats.QueueTime.Max >= time.Millisecond*10)
+	assert.Equal(t, float64(1), stats.Params[paramLimit])
+	assert.Equal(t, 0.005, stats.Params[paramTarget])
+	assert.Equal(t, 0.02, stats.Params[paramInterval])
+
+	c.reset()
+	assert.Equal(t, int64(0), c.stats().Total)
+}
+
+func TestCoDel_AllowWithin(t *testing.T) {
+	c := newCoDel(1, time.Millisecond*5, time.Hour, timex.RealClock())
+	p, err := c.allowWithin("", time.Millisecond)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = c.allowWithin("", time.Millisecond*10)
+	assert.ErrorIs(t, err, ErrQueueTimeout)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 0, c.waiters.Len())
+	_, err = c.allowWithin("", 0)
+	assert.ErrorIs(t, err, ErrQueueTimeout)
+	p.Accept()
+}
+
+func TestCoDel_Overload(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	c := newCoDel(1, time.Millisecond*5, time.Millisecond*100, clock)
+	// keep the queue busy.
+	c.waiters.PushBack(&codelWaiter{ready: make(chan struct{})})
+
+	c.observe(clock.Now(), time.Millisecond*10)
+	clock.Advance(time.Millisecond * 50)
+	c.observe(clock.Now(), time.Millisecond*10)
+	assert.False(t, c.stats().Overloaded)
+
+	// a delay below target restarts the interval.
+	c.observe(clock.Now(), time.Millisecond)
+	clock.Advance(time.Millisecond * 60)
+	c.observe(clock.Now(), time.Millisecond*10)
+	assert.False(t, c.stats().Overloaded)
+
+	clock.Advance(time.Millisecond * 100)
+	c.observe(clock.Now(), time.Millisecond*10)
+	assert.True(t, c.stats().Overloaded)
+
+	// the newest calls are served quickly in LIFO order, it's over only if the queue drains.
+	c.observe(clock.Now(), time.Millisecond)
+	assert.True(t, c.stats().Overloaded)
+	c.waiters.Init()
+	c.observe(clock.Now(), time.Millisecond)
+	assert.False(t, c.stats().Overloaded)
+}
+
+func TestCoDel_LIFO(t *testing.T) {
+	c := newCoDel(1, time.Second, time.Second*2, timex.RealClock())
+	p, err := c.allow()
+	assert.NoError(t, err)
+
+	c.lock.Lock()
+	c.overloaded = true
+	c.lock.Unlock()
+
+	order := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		i := i
+		go func() {
+			p, err := c.allow()
+			assert.NoError(t, err)
+			order <- i
+			p.Accept()
+		}()
+		// wait until queued
+		for {
+			c.lock.Lock()
+			n := c.waiters.Len()
+			c.lock.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	p.Accept()
+	assert.Equal(t, 1, <-order)
+	assert.Equal(t, 0, <-order)
+}
+
+func TestCoDel_Tune(t *testing.T) {
+	c := newCoDel(1, time.Millisecond*5, time.Millisecond*100, timex.NewManualClock(0))
+	assert.NoError(t, c.tune(paramLimit, 2))
+	assert.NoError(t, c.tune(paramTarget, 0.01))
+	assert.NoError(t, c.tune(paramInterval, 0.2))
+	assert.ErrorIs(t, c.tune(paramLimit, 0.5), ErrInvalidParam)
+	assert.ErrorIs(t, c.tune(paramTarget, 0.5), ErrInvalidParam)
+	assert.ErrorIs(t, c.tune(paramInterval, 0.001), ErrInvalidParam)
+	assert.ErrorIs(t, c.tune("any", 1), ErrUnknownParam)
+	assert.Equal(t, int64(2), c.limit)
+	assert.Equal(t, time.Millisecond*10, c.target)
+	assert.Equal(t, time.Millisecond*200, c.interval)
+}
+
+func TestCoDel_ForcedClosed(t *testing.T) {
+	b := NewCoDel(1, time.Millisecond*5, time.Millisecond*100).(*circuitBreaker)
+	b.Force(StateForcedClosed)
+	p1, err := b.Allow()
+	assert.NoError(t, err)
+	p2, err := b.Allow()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), b.Stats().InFlight)
+
+	p1.Accept()
+	p2.Reject("any")
+	assert.Equal(t, int64(0), b.Stats().InFlight)
+}
@@ -2,10 +2,13 @@ package breaker
 
 import (
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chenquan/sqlbreaker/pkg/collection"
 	"github.com/chenquan/sqlbreaker/pkg/mathx"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
 )
 
 const (
@@ -14,40 +17,174 @@ const (
 	buckets    = 40
 	k          = 1.5
 	protection = 5
+
+	paramK          = "k"
+	paramProtection = "protection"
+	paramSlowStart  = "slowStart"
+	paramMaxDrop    = "maxDropRatio"
+	paramProbe      = "probeInterval"
+
+	// the admission ratio that the slow start ramps up from.
+	slowStartFloor = 0.1
 )
 
-// googleBreaker is a netflixBreaker pattern from google.
-// see Client-Side Throttling section in https://landing.google.com/sre/sre-book/chapters/handling-overload/
-type googleBreaker struct {
-	k     float64
-	stat  *collection.RollingWindow
-	proba *mathx.Proba
-}
+type (
+	// googleBreaker is a netflixBreaker pattern from google.
+	// see Client-Side Throttling section in https://landing.google.com/sre/sre-book/chapters/handling-overload/
+	googleBreaker struct {
+		// lock serializes the tuning of params, which are read without locks.
+		lock   sync.Mutex
+		params atomic.Value // googleParams
+		stat   requestStat
+		proba  mathx.Decider
+
+		// the slow start after recovery, see WithSlowStart.
+		ramp Ramp
+		// slowStart is the period of the ramp in nanoseconds, 0 if disabled.
+		slowStart int64
+		// shedding is 1 if requests were dropped since the last ramp started.
+		shedding int32
+		// rampStart is when the last ramp started, -1 if none.
+		rampStart int64
+		clock     timex.Clock
+		// lastAdmit is when the last request was admitted while shedding, see WithProbeInterval.
+		lastAdmit int64
+	}
+
+	googleParams struct {
+		k          float64
+		protection int64
+		// maxDropRatio caps the drop ratio, 0 if not capped.
+		maxDropRatio float64
+		// probeInterval is the longest time without admitting a request, 0 if not guaranteed.
+		probeInterval time.Duration
+	}
+
+	// requestStat counts the accepted requests and all the requests of a googleBreaker.
+	requestStat interface {
+		// add adds 1 for an accepted request, 0 for a failed one.
+		add(v float64)
+		history() (accepts, total float64)
+		reset()
+	}
 
-func newGoogleBreaker() *googleBreaker {
-	bucketDuration := time.Duration(int64(window) / int64(buckets))
-	st := collection.NewRollingWindow(buckets, bucketDuration)
-	return &googleBreaker{
-		stat:  st,
-		k:     k,
-		proba: mathx.NewProba(),
+	// windowStat counts the requests in a rolling window, the requests expire bucket by bucket.
+	windowStat struct {
+		*collection.AtomicRollingWindow
 	}
+
+	// decayStat counts the requests with exponential decay, the requests fade out smoothly.
+	decayStat struct {
+		*collection.DecayCounter
+	}
+)
+
+func newGoogleBreaker(opts throttleOptions) *googleBreaker {
+	var st requestStat
+	if opts.halfLife > 0 {
+		st = decayStat{collection.NewDecayCounter(opts.halfLife, collection.WithDecayCounterClock(opts.clock))}
+	} else {
+		bucketDuration := time.Duration(int64(window) / int64(buckets))
+		st = windowStat{collection.NewAtomicRollingWindow(buckets, bucketDuration,
+			collection.WithAtomicRollingWindowClock(opts.clock))}
+	}
+
+	b := &googleBreaker{
+		stat:      st,
+		proba:     opts.newDecider(),
+		ramp:      opts.ramp,
+		slowStart: int64(opts.slowStart),
+		rampStart: -1,
+		clock:     opts.clock,
+	}
+	b.setParams(googleParams{
+		k:             k,
+		protection:    protection,
+		maxDropRatio:  opts.maxDropRatio,
+		probeInterval: opts.probeInterval,
+	})
+
+	return b
 }
 
 func (b *googleBreaker) accept() error {
-	accepts, total := b.history()
-	weightedAccepts := b.k * float64(accepts)
-	// https://landing.google.com/sre/sre-book/chapters/handling-overload/#eq2101
-	dropRatio := math.Max(0, (float64(total-protection)-weightedAccepts)/float64(total+1))
+	params := b.getParams()
+	shedRatio := b.dropRatio()
+	dropRatio := shedRatio
+	period := atomic.LoadInt64(&b.slowStart)
+	if period > 0 {
+		dropRatio = b.warmup(dropRatio, time.Duration(period))
+	}
+	if params.maxDropRatio > 0 {
+		dropRatio = math.Min(dropRatio, params.maxDropRatio)
+	}
 	if dropRatio <= 0 {
 		return nil
 	}
 
-	if b.proba.TrueOnProba(dropRatio) {
-		return ErrServiceUnavailable
+	if !b.proba.TrueOnProba(dropRatio) {
+		if params.probeInterval > 0 {
+			atomic.StoreInt64(&b.lastAdmit, int64(b.clock.Now()))
+		}
+		return nil
 	}
 
-	return nil
+	if params.probeInterval > 0 && b.probe(params.probeInterval) {
+		return nil
+	}
+
+	// only the drops by the statistics call for a ramp, not the ones by the ramp itself.
+	if period > 0 && shedRatio > 0 && atomic.LoadInt32(&b.shedding) == 0 {
+		atomic.StoreInt32(&b.shedding, 1)
+	}
+
+	return ErrServiceUnavailable
+}
+
+// probe reports whether the request to drop should be admitted as a probe,
+// because no request has been admitted for interval.
+func (b *googleBreaker) probe(interval time.Duration) bool {
+	now := b.clock.Now()
+	last := atomic.LoadInt64(&b.lastAdmit)
+	return now-time.Duration(last) >= interval && atomic.CompareAndSwapInt64(&b.lastAdmit, last, int64(now))
+}
+
+func (b *googleBreaker) dropRatio() float64 {
+	accepts, total := b.stat.history()
+	return b.calcDropRatio(accepts, total)
+}
+
+func (b *googleBreaker) calcDropRatio(accepts, total float64) float64 {
+	params := b.getParams()
+	weightedAccepts := params.k * accepts
+	protection := float64(params.protection)
+	// https://landing.google.com/sre/sre-book/chapters/handling-overload/#eq2101
+	return math.Max(0, (total-protection-weightedAccepts)/(total+1))
+}
+
+// warmup starts a ramp once the drop ratio is back to 0 after requests were dropped,
+// and returns the drop ratio raised to what the ramp doesn't admit yet.
+func (b *googleBreaker) warmup(dropRatio float64, period time.Duration) float64 {
+	if dropRatio > 0 {
+		return dropRatio
+	}
+
+	now := b.clock.Now()
+	if atomic.LoadInt32(&b.shedding) == 1 && atomic.CompareAndSwapInt32(&b.shedding, 1, 0) {
+		atomic.StoreInt64(&b.rampStart, int64(now))
+	}
+
+	return 1 - b.ramp.admitRatio(b.progress(now, period))
+}
+
+// progress returns how far the last ramp is at now, 1 if it's over.
+func (b *googleBreaker) progress(now, period time.Duration) float64 {
+	start := atomic.LoadInt64(&b.rampStart)
+	if start < 0 {
+		return 1
+	}
+
+	return math.Min(1, float64(now-time.Duration(start))/float64(period))
 }
 
 func (b *googleBreaker) allow() (internalPromise, error) {
@@ -55,26 +192,147 @@ func (b *googleBreaker) allow() (internalPromise, error) {
 		return nil, err
 	}
 
+	return b.promise(), nil
+}
+
+func (b *googleBreaker) promise() internalPromise {
 	return googlePromise{
 		b: b,
-	}, nil
+	}
+}
+
+func (b *googleBreaker) stats() Stats {
+	accepts, total := b.stat.history()
+	current := b.getParams()
+	params := map[string]float64{
+		paramK:          current.k,
+		paramProtection: float64(current.protection),
+	}
+
+	stats := Stats{
+		Accepts:   int64(math.Round(accepts)),
+		Total:     int64(math.Round(total)),
+		DropRatio: b.calcDropRatio(accepts, total),
+		Params:    params,
+	}
+
+	if period := atomic.LoadInt64(&b.slowStart); period > 0 {
+		params[paramSlowStart] = time.Duration(period).Seconds()
+		if progress := b.progress(b.clock.Now(), time.Duration(period)); stats.DropRatio <= 0 && progress < 1 {
+			stats.DropRatio = 1 - b.ramp.admitRatio(progress)
+			// the start of a ramp tells from no ramp.
+			stats.Warmup = math.Max(progress, math.SmallestNonzeroFloat64)
+		}
+	}
+	if current.maxDropRatio > 0 {
+		params[paramMaxDrop] = current.maxDropRatio
+		stats.DropRatio = math.Min(stats.DropRatio, current.maxDropRatio)
+	}
+	if current.probeInterval > 0 {
+		params[paramProbe] = current.probeInterval.Seconds()
+	}
+
+	return stats
+}
+
+func (b *googleBreaker) reset() {
+	b.stat.reset()
+	atomic.StoreInt32(&b.shedding, 0)
+	atomic.StoreInt64(&b.rampStart, -1)
+}
+
+// recover clears the statistics like reset, but ramps up by the slow start if enabled,
+// rather than admitting all requests at once.
+func (b *googleBreaker) recover() {
+	b.stat.reset()
+	atomic.StoreInt32(&b.shedding, 0)
+	if atomic.LoadInt64(&b.slowStart) > 0 {
+		atomic.StoreInt64(&b.rampStart, int64(b.clock.Now()))
+	} else {
+		atomic.StoreInt64(&b.rampStart, -1)
+	}
+}
+
+func (b *googleBreaker) tune(param string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return ErrInvalidParam
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	params := b.getParams()
+	switch param {
+	case paramK:
+		// k below 1 drops requests even if all of them succeed.
+		if value < 1 {
+			return ErrInvalidParam
+		}
+		params.k = value
+	case paramProtection:
+		params.protection = int64(value)
+	case paramMaxDrop:
+		if value > 1 {
+			return ErrInvalidParam
+		}
+		params.maxDropRatio = value
+	case paramProbe:
+		params.probeInterval = time.Duration(value * float64(time.Second))
+	case paramSlowStart:
+		atomic.StoreInt64(&b.slowStart, int64(value*float64(time.Second)))
+		return nil
+	default:
+		return ErrUnknownParam
+	}
+	b.setParams(params)
+
+	return nil
+}
+
+func (b *googleBreaker) getParams() googleParams {
+	return b.params.Load().(googleParams)
+}
+
+func (b *googleBreaker) setParams(params googleParams) {
+	b.params.Store(params)
 }
 
 func (b *googleBreaker) markSuccess() {
-	b.stat.Add(1)
+	b.stat.add(1)
 }
 
 func (b *googleBreaker) markFailure() {
-	b.stat.Add(0)
+	b.stat.add(0)
 }
 
 func (b *googleBreaker) history() (accepts, total int64) {
-	b.stat.Reduce(func(b *collection.Bucket) {
-		accepts += int64(b.Sum)
-		total += b.Count
-	})
+	a, t := b.stat.history()
+	return int64(math.Round(a)), int64(math.Round(t))
+}
 
-	return
+func (s windowStat) add(v float64) {
+	s.Add(int64(v))
+}
+
+func (s windowStat) history() (accepts, total float64) {
+	sum, count := s.Sum()
+	return float64(sum), float64(count)
+}
+
+func (s windowStat) reset() {
+	s.Reset()
+}
+
+func (s decayStat) add(v float64) {
+	s.Add(v)
+}
+
+func (s decayStat) history() (accepts, total float64) {
+	return s.Value()
+}
+
+func (s decayStat) reset() {
+	s.Reset()
 }
 
 type googlePromise struct {
@@ -88,3 +346,5 @@ func (p googlePromise) Accept() {
 func (p googlePromise) Reject() {
 	p.b.markFailure()
 }
+
+func (p googlePromise) stateless() {}
@@ -2,12 +2,15 @@ package breaker
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/chenquan/sqlbreaker/pkg/collection"
 	"github.com/chenquan/sqlbreaker/pkg/mathx"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,31 +19,54 @@ const (
 	testInterval = time.Millisecond * 10
 )
 
-func getGoogleBreaker() *googleBreaker {
-	st := collection.NewRollingWindow(testBuckets, testInterval)
-	return &googleBreaker{
-		stat:  st,
-		k:     5,
-		proba: mathx.NewProba(),
+func getGoogleBreaker(clock timex.Clock) *googleBreaker {
+	st := collection.NewAtomicRollingWindow(testBuckets, testInterval, collection.WithAtomicRollingWindowClock(clock))
+	b := &googleBreaker{
+		stat:      windowStat{st},
+		proba:     mathx.NewProba(),
+		rampStart: -1,
+		clock:     clock,
 	}
+	b.setParams(googleParams{k: 5, protection: protection})
+	return b
 }
 
-func markSuccessWithDuration(b *googleBreaker, count int, sleep time.Duration) {
+// neverDecider decides false on any probability.
+type neverDecider struct{}
+
+func (neverDecider) TrueOnProba(float64) bool {
+	return false
+}
+
+// shed makes b drop a request, then expires the failures, as a breaker recovers.
+func shed(b *googleBreaker, clock *timex.ManualClock) {
+	proba := b.proba
+	b.proba = certainDecider{}
+	for i := 0; i < 100; i++ {
+		b.markFailure()
+	}
+	_ = b.accept()
+	b.proba = proba
+	clock.Advance(testInterval * testBuckets)
+}
+
+func markSuccessWithDuration(b *googleBreaker, clock *timex.ManualClock, count int, elapse time.Duration) {
 	for i := 0; i < count; i++ {
 		b.markSuccess()
-		time.Sleep(sleep)
+		clock.Advance(elapse)
 	}
 }
 
-func markFailedWithDuration(b *googleBreaker, count int, sleep time.Duration) {
+func markFailedWithDuration(b *googleBreaker, clock *timex.ManualClock, count int, elapse time.Duration) {
 	for i := 0; i < count; i++ {
 		b.markFailure()
-		time.Sleep(sleep)
+		clock.Advance(elapse)
 	}
 }
 
 func TestGoogleBreakerClose(t *testing.T) {
-	b := getGoogleBreaker()
+	clock := timex.NewManualClock(0)
+	b := getGoogleBreaker(clock)
 	markSuccess(b, 80)
 	assert.Nil(t, b.accept())
 	markSuccess(b, 120)
@@ -48,35 +74,37 @@ func TestGoogleBreakerClose(t *testing.T) {
 }
 
 func TestGoogleBreakerOpen(t *testing.T) {
-	b := getGoogleBreaker()
+	clock := timex.NewManualClock(0)
+	b := getGoogleBreaker(clock)
 	markSuccess(b, 10)
 	assert.Nil(t, b.accept())
 	markFailed(b, 100000)
-	time.Sleep(testInterval * 2)
+	clock.Advance(testInterval * 2)
 	verify(t, func() bool {
 		return b.accept() != nil
 	})
 }
 
 func TestGoogleBreakerHalfOpen(t *testing.T) {
-	b := getGoogleBreaker()
+	clock := timex.NewManualClock(0)
+	b := getGoogleBreaker(clock)
 	assert.Nil(t, b.accept())
 	t.Run("accept single failed/accept", func(t *testing.T) {
 		markFailed(b, 10000)
-		time.Sleep(testInterval * 2)
+		clock.Advance(testInterval * 2)
 		verify(t, func() bool {
 			return b.accept() != nil
 		})
 	})
 	t.Run("accept single failed/allow", func(t *testing.T) {
 		markFailed(b, 10000)
-		time.Sleep(testInterval * 2)
+		clock.Advance(testInterval * 2)
 		verify(t, func() bool {
 			_, err := b.allow()
 			return err != nil
 		})
 	})
-	time.Sleep(testInterval * testBuckets)
+	clock.Advance(testInterval * testBuckets)
 	t.Run("accept single succeed", func(t *testing.T) {
 		assert.Nil(t, b.accept())
 		markSuccess(b, 10000)
@@ -87,14 +115,15 @@ func TestGoogleBreakerHalfOpen(t *testing.T) {
 }
 
 func TestGoogleBreakerSelfProtection(t *testing.T) {
+	clock := timex.NewManualClock(0)
 	t.Run("total request < 100", func(t *testing.T) {
-		b := getGoogleBreaker()
+		b := getGoogleBreaker(clock)
 		markFailed(b, 4)
-		time.Sleep(testInterval)
+		clock.Advance(testInterval)
 		assert.Nil(t, b.accept())
 	})
 	t.Run("total request > 100, total < 2 * success", func(t *testing.T) {
-		b := getGoogleBreaker()
+		b := getGoogleBreaker(clock)
 		size := rand.Intn(10000)
 		accepts := size + 1
 		markSuccess(b, accepts)
@@ -103,39 +132,196 @@ func TestGoogleBreakerSelfProtection(t *testing.T) {
 	})
 }
 
+func TestGoogleBreakerDiffusion(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := getGoogleBreaker(clock)
+	b.setParams(googleParams{k: k, protection: protection})
+	b.proba = mathx.NewDiffusion()
+	markSuccessWithDuration(b, clock, 20, 0)
+	markFailedWithDuration(b, clock, 80, 0)
+
+	ratio := b.dropRatio()
+	assert.True(t, ratio > 0)
+	var drops int
+	for i := 0; i < 1000; i++ {
+		if b.accept() != nil {
+			drops++
+		}
+	}
+	assert.InDelta(t, ratio*1000, drops, 1)
+}
+
+func TestGoogleBreakerSlowStart(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := getGoogleBreaker(clock)
+	period := time.Second * 10
+	b.slowStart = int64(period)
+
+	// no ramp before shedding.
+	assert.Equal(t, float64(0), b.warmup(0, period))
+	assert.Equal(t, 0.5, b.warmup(0.5, period))
+	assert.Equal(t, float64(0), b.warmup(0, period))
+
+	shed(b, clock)
+	assert.InDelta(t, 0.9, b.warmup(0, period), 1e-9)
+	clock.Advance(period / 2)
+	assert.InDelta(t, 0.45, b.warmup(0, period), 1e-9)
+	stats := b.stats()
+	assert.InDelta(t, 0.45, stats.DropRatio, 1e-9)
+	assert.Equal(t, 0.5, stats.Warmup)
+	assert.Equal(t, float64(10), stats.Params[paramSlowStart])
+
+	clock.Advance(period / 2)
+	assert.Equal(t, float64(0), b.warmup(0, period))
+	assert.Equal(t, float64(0), b.stats().Warmup)
+
+	b.ramp = RampExponential
+	shed(b, clock)
+	b.warmup(0, period)
+	clock.Advance(period / 2)
+	assert.InDelta(t, 1-0.1*math.Sqrt(10), b.warmup(0, period), 1e-9)
+
+	b.reset()
+	assert.Equal(t, float64(0), b.warmup(0, period))
+	assert.NoError(t, b.tune(paramSlowStart, 0))
+	assert.Equal(t, int64(0), b.slowStart)
+}
+
+func TestGoogleBreakerSlowStartNotDropped(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := getGoogleBreaker(clock)
+	b.slowStart = int64(time.Second * 10)
+	b.proba = neverDecider{}
+	for i := 0; i < 100; i++ {
+		b.markFailure()
+	}
+
+	// the drop ratio goes above 0, but nothing is dropped.
+	assert.True(t, b.dropRatio() > 0)
+	assert.NoError(t, b.accept())
+	clock.Advance(testInterval * testBuckets)
+	assert.NoError(t, b.accept())
+	assert.Equal(t, int64(-1), b.rampStart)
+	assert.Equal(t, float64(0), b.stats().DropRatio)
+}
+
+func TestGoogleBreakerMaxDropRatio(t *testing.T) {
+	b := getGoogleBreaker(timex.NewManualClock(0))
+	assert.NoError(t, b.tune(paramMaxDrop, 0.5))
+	assert.ErrorIs(t, b.tune(paramMaxDrop, 1.5), ErrInvalidParam)
+	for i := 0; i < 100; i++ {
+		b.markFailure()
+	}
+
+	b.proba = mathx.NewDiffusion()
+	var dropped int
+	for i := 0; i < 100; i++ {
+		if b.accept() != nil {
+			dropped++
+		}
+	}
+	assert.Equal(t, 50, dropped)
+
+	stats := b.stats()
+	assert.Equal(t, 0.5, stats.DropRatio)
+	assert.Equal(t, 0.5, stats.Params[paramMaxDrop])
+}
+
+func TestGoogleBreakerProbe(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := getGoogleBreaker(clock)
+	b.proba = certainDecider{}
+	assert.NoError(t, b.tune(paramProbe, 0.1))
+	assert.Equal(t, 0.1, b.stats().Params[paramProbe])
+	// the failures are added at every step, as they expire in the test window.
+	fail := func() {
+		for i := 0; i < 100; i++ {
+			b.markFailure()
+		}
+	}
+
+	fail()
+	assert.ErrorIs(t, b.accept(), ErrServiceUnavailable)
+	clock.Advance(time.Millisecond * 100)
+	fail()
+	assert.NoError(t, b.accept())
+	assert.ErrorIs(t, b.accept(), ErrServiceUnavailable)
+	clock.Advance(time.Millisecond * 50)
+	fail()
+	assert.ErrorIs(t, b.accept(), ErrServiceUnavailable)
+	clock.Advance(time.Millisecond * 50)
+	fail()
+	assert.NoError(t, b.accept())
+}
+
+func TestWithSlowStart(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := NewBreaker(WithSlowStart(time.Second*10, RampLinear), WithClock(clock), WithErrorDiffusion()).(*circuitBreaker)
+	var dropped int
+	for i := 0; i < 100; i++ {
+		p, err := b.Allow()
+		if err != nil {
+			dropped++
+			continue
+		}
+		p.Reject("fail")
+	}
+	assert.True(t, dropped > 0)
+
+	// the failures expire, the breaker recovers and ramps up.
+	clock.Advance(window + time.Second)
+	admitted := 0
+	for i := 0; i < 100; i++ {
+		if p, err := b.Allow(); err == nil {
+			admitted++
+			p.Accept()
+		}
+	}
+	assert.Equal(t, 10, admitted)
+
+	clock.Advance(time.Second * 5)
+	stats := b.Stats()
+	assert.Equal(t, 0.5, stats.Warmup)
+	assert.InDelta(t, 0.45, stats.DropRatio, 1e-9)
+
+	clock.Advance(time.Second * 5)
+	assert.Equal(t, float64(0), b.Stats().DropRatio)
+}
+
 func TestGoogleBreakerHistory(t *testing.T) {
+	clock := timex.NewManualClock(0)
 	var b *googleBreaker
 	var accepts, total int64
 
-	sleep := testInterval
+	elapse := testInterval
 	t.Run("accepts == total", func(t *testing.T) {
-		b = getGoogleBreaker()
-		markSuccessWithDuration(b, 10, sleep/2)
+		b = getGoogleBreaker(clock)
+		markSuccessWithDuration(b, clock, 10, elapse/2)
 		accepts, total = b.history()
 		assert.Equal(t, int64(10), accepts)
 		assert.Equal(t, int64(10), total)
 	})
 
 	t.Run("fail == total", func(t *testing.T) {
-		b = getGoogleBreaker()
-		markFailedWithDuration(b, 10, sleep/2)
+		b = getGoogleBreaker(clock)
+		markFailedWithDuration(b, clock, 10, elapse/2)
 		accepts, total = b.history()
 		assert.Equal(t, int64(0), accepts)
 		assert.Equal(t, int64(10), total)
 	})
 
 	t.Run("accepts = 1/2 * total, fail = 1/2 * total", func(t *testing.T) {
-		b = getGoogleBreaker()
-		markFailedWithDuration(b, 5, sleep/2)
-		markSuccessWithDuration(b, 5, sleep/2)
+		b = getGoogleBreaker(clock)
+		markFailedWithDuration(b, clock, 5, elapse/2)
+		markSuccessWithDuration(b, clock, 5, elapse/2)
 		accepts, total = b.history()
 		assert.Equal(t, int64(5), accepts)
 		assert.Equal(t, int64(10), total)
 	})
 
 	t.Run("auto reset rolling counter", func(t *testing.T) {
-		b = getGoogleBreaker()
-		time.Sleep(testInterval * testBuckets)
+		b = getGoogleBreaker(clock)
+		clock.Advance(testInterval * testBuckets)
 		accepts, total = b.history()
 		assert.Equal(t, int64(0), accepts)
 		assert.Equal(t, int64(0), total)
@@ -143,7 +329,7 @@ func TestGoogleBreakerHistory(t *testing.T) {
 }
 
 func BenchmarkGoogleBreakerAllow(b *testing.B) {
-	breaker := getGoogleBreaker()
+	breaker := getGoogleBreaker(timex.RealClock())
 	b.ResetTimer()
 	for i := 0; i <= b.N; i++ {
 		breaker.accept()
@@ -155,6 +341,89 @@ func BenchmarkGoogleBreakerAllow(b *testing.B) {
 	}
 }
 
+// lockedStat is the requestStat on top of the RollingWindow with locks, to compare with in benchmarks.
+type lockedStat struct {
+	*collection.RollingWindow
+}
+
+func (s lockedStat) add(v float64) {
+	s.Add(v)
+}
+
+func (s lockedStat) history() (accepts, total float64) {
+	s.Reduce(func(b *collection.Bucket) {
+		accepts += b.Sum
+		total += float64(b.Count)
+	})
+	return
+}
+
+func (s lockedStat) reset() {
+	s.Reset()
+}
+
+func BenchmarkGoogleBreakerParallel(b *testing.B) {
+	bucketDuration := time.Duration(int64(window) / int64(buckets))
+	breakers := []struct {
+		name string
+		new  func() *googleBreaker
+	}{
+		{
+			name: "atomic",
+			new: func() *googleBreaker {
+				return newGoogleBreaker(throttleOptions{clock: timex.RealClock()})
+			},
+		},
+		{
+			name: "locked",
+			new: func() *googleBreaker {
+				brk := newGoogleBreaker(throttleOptions{clock: timex.RealClock()})
+				brk.stat = lockedStat{collection.NewRollingWindow(buckets, bucketDuration)}
+				brk.proba = mathx.NewProbaWithSource(rand.NewSource(1))
+				return brk
+			},
+		},
+	}
+
+	for _, brk := range breakers {
+		for _, goroutines := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("%s/goroutines-%d", brk.name, goroutines), func(b *testing.B) {
+				breaker := brk.new()
+				call := func(i int) {
+					p, err := breaker.allow()
+					if err != nil {
+						return
+					}
+					if i%10 == 0 {
+						p.Reject()
+					} else {
+						p.Accept()
+					}
+				}
+
+				b.ReportAllocs()
+				if goroutines == 1 {
+					for i := 0; i < b.N; i++ {
+						call(i)
+					}
+					return
+				}
+
+				// RunParallel starts parallelism * GOMAXPROCS goroutines.
+				procs := runtime.GOMAXPROCS(0)
+				b.SetParallelism((goroutines + procs - 1) / procs)
+				b.RunParallel(func(pb *testing.PB) {
+					var i int
+					for pb.Next() {
+						call(i)
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
 func markSuccess(b *googleBreaker, count int) {
 	for i := 0; i < count; i++ {
 		p, err := b.allow()
@@ -183,3 +452,38 @@ func verify(t *testing.T, fn func() bool) {
 	}
 	assert.True(t, count >= 80, fmt.Sprintf("should be greater than 80, actual %d", count))
 }
+
+func TestGoogleBreakerDecay(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	windowed := getGoogleBreaker(clock)
+	decayed := getGoogleBreaker(clock)
+	decayed.stat = decayStat{collection.NewDecayCounter(testInterval*testBuckets/2, collection.WithDecayCounterClock(clock))}
+
+	for _, b := range []*googleBreaker{windowed, decayed} {
+		for i := 0; i < 100; i++ {
+			if i < 10 {
+				b.markSuccess()
+			}
+			b.markFailure()
+		}
+	}
+	windowedRatio, decayedRatio := windowed.dropRatio(), decayed.dropRatio()
+	assert.True(t, windowedRatio > 0)
+	assert.InDelta(t, windowedRatio, decayedRatio, 1e-3)
+
+	// the rolling window keeps all requests until the buckets expire,
+	// while the decayed requests fade out at once.
+	clock.Advance(testInterval * 3)
+	assert.Equal(t, windowedRatio, windowed.dropRatio())
+	ratio := decayed.dropRatio()
+	assert.True(t, ratio > 0 && ratio < decayedRatio)
+
+	accepts, total := decayed.history()
+	assert.True(t, accepts < 10)
+	assert.True(t, total < 110)
+
+	decayed.reset()
+	accepts, total = decayed.history()
+	assert.Equal(t, int64(0), accepts)
+	assert.Equal(t, int64(0), total)
+}
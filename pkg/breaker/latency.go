@@ -0,0 +1,116 @@
+package breaker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+// LatencyStats is the statistics of the latencies of calls in the last 10 seconds.
+type LatencyStats struct {
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+const (
+	// the latencies of the last 10 seconds are reported in Stats.
+	latencyWindow  = time.Second * 10
+	latencyBuckets = 10
+)
+
+// latencyRecorder records the latencies in seconds, in total and per operation kind.
+// The latencies are added without locks, the lock only serializes adding the operation kinds.
+type latencyRecorder struct {
+	lock sync.Mutex
+	all  *collection.AtomicRollingHistogram
+	// ops holds a map[string]*collection.AtomicRollingHistogram, which is copied on write.
+	ops   atomic.Value
+	clock timex.Clock
+}
+
+func newLatencyRecorder(clock timex.Clock) *latencyRecorder {
+	lr := &latencyRecorder{
+		all:   newLatencyHistogram(clock),
+		clock: clock,
+	}
+	lr.ops.Store(make(map[string]*collection.AtomicRollingHistogram))
+
+	return lr
+}
+
+func (lr *latencyRecorder) add(op string, latency time.Duration) {
+	v := latency.Seconds()
+
+	h, ok := lr.getOps()[op]
+	if !ok {
+		lr.lock.Lock()
+		ops := lr.getOps()
+		if h, ok = ops[op]; !ok {
+			h = newLatencyHistogram(lr.clock)
+			copied := make(map[string]*collection.AtomicRollingHistogram, len(ops)+1)
+			for name, other := range ops {
+				copied[name] = other
+			}
+			copied[op] = h
+			lr.ops.Store(copied)
+		}
+		lr.lock.Unlock()
+	}
+
+	h.Add(v)
+	lr.all.Add(v)
+}
+
+func (lr *latencyRecorder) stats() (LatencyStats, map[string]LatencyStats) {
+	current := lr.getOps()
+	if len(current) == 0 {
+		return LatencyStats{}, nil
+	}
+
+	ops := make(map[string]LatencyStats, len(current))
+	for op, h := range current {
+		snapshot := h.Snapshot()
+		ops[op] = newLatencyStats(&snapshot)
+	}
+
+	all := lr.all.Snapshot()
+	return newLatencyStats(&all), ops
+}
+
+func (lr *latencyRecorder) reset() {
+	lr.lock.Lock()
+	lr.all.Reset()
+	lr.ops.Store(make(map[string]*collection.AtomicRollingHistogram))
+	lr.lock.Unlock()
+}
+
+func (lr *latencyRecorder) getOps() map[string]*collection.AtomicRollingHistogram {
+	return lr.ops.Load().(map[string]*collection.AtomicRollingHistogram)
+}
+
+func newLatencyHistogram(clock timex.Clock) *collection.AtomicRollingHistogram {
+	return collection.NewAtomicRollingHistogram(latencyBuckets, latencyWindow/latencyBuckets,
+		collection.WithAtomicRollingHistogramClock(clock))
+}
+
+func newLatencyStats(h *collection.Histogram) LatencyStats {
+	return LatencyStats{
+		Count: h.Count,
+		Mean:  seconds(h.Mean()),
+		P50:   seconds(h.Quantile(0.5)),
+		P95:   seconds(h.Quantile(0.95)),
+		P99:   seconds(h.Quantile(0.99)),
+		Max:   seconds(h.Max),
+	}
+}
+
+func seconds(v float64) time.Duration {
+	return time.Duration(v * float64(time.Second))
+}
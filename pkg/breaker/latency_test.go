@@ -0,0 +1,72 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyRecorder(t *testing.T) {
+	lr := newLatencyRecorder(timex.NewManualClock(0))
+	all, ops := lr.stats()
+	assert.Equal(t, LatencyStats{}, all)
+	assert.Nil(t, ops)
+
+	for i := 1; i <= 100; i++ {
+		lr.add("query", time.Duration(i)*time.Millisecond)
+	}
+	lr.add("exec", time.Second)
+
+	all, ops = lr.stats()
+	assert.Equal(t, int64(101), all.Count)
+	assert.Equal(t, time.Second, all.Max)
+	assert.Equal(t, int64(100), ops["query"].Count)
+	assert.Equal(t, 100*time.Millisecond, ops["query"].Max)
+	assert.InEpsilon(t, float64(50*time.Millisecond), float64(ops["query"].P50), 0.1)
+	assert.InEpsilon(t, float64(95*time.Millisecond), float64(ops["query"].P95), 0.1)
+	assert.InEpsilon(t, float64(99*time.Millisecond), float64(ops["query"].P99), 0.1)
+	assert.InEpsilon(t, float64(50500*time.Microsecond), float64(ops["query"].Mean), 0.01)
+	assert.Equal(t, int64(1), ops["exec"].Count)
+
+	lr.reset()
+	all, ops = lr.stats()
+	assert.Equal(t, LatencyStats{}, all)
+	assert.Nil(t, ops)
+}
+
+func TestCircuitBreaker_Latency(t *testing.T) {
+	b := NewBreaker()
+	for i := 0; i < 10; i++ {
+		allow, err := b.Allow()
+		assert.NoError(t, err)
+		p, ok := allow.(LatencyPromise)
+		assert.True(t, ok)
+		if i%2 == 0 {
+			p.AcceptWithLatency("exec", time.Millisecond)
+		} else {
+			p.RejectWithLatency("query", "fail", time.Second)
+		}
+	}
+
+	stats := b.(Inspector).Stats()
+	assert.Equal(t, int64(5), stats.Accepts)
+	assert.Equal(t, int64(10), stats.Total)
+	assert.Equal(t, int64(10), stats.Latency.Count)
+	assert.Equal(t, time.Second, stats.OpLatency["query"].Max)
+	assert.Equal(t, time.Millisecond, stats.OpLatency["exec"].Max)
+	assert.NotEmpty(t, stats.Reasons)
+
+	b.(Controller).Reset()
+	assert.Equal(t, int64(0), b.(Inspector).Stats().Latency.Count)
+}
+
+func BenchmarkLatencyRecorderAdd(b *testing.B) {
+	lr := newLatencyRecorder(timex.RealClock())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lr.add("query", time.Millisecond)
+		}
+	})
+}
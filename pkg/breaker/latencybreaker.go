@@ -0,0 +1,195 @@
+package breaker
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/mathx"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+const (
+	// the least number of samples in the window to start shedding.
+	latencyProtection = 20
+
+	paramSLO      = "slo"
+	paramQuantile = "quantile"
+)
+
+// latencyBreaker sheds requests when the latency at the quantile goes over the slo,
+// the drop ratio is proportional to how far the latency is over the slo.
+type latencyBreaker struct {
+	// lock guards slo, quantile and protection, which can be tuned at runtime.
+	lock       sync.RWMutex
+	slo        time.Duration
+	quantile   float64
+	protection int64
+
+	// latencies in seconds
+	stat     *collection.RollingHistogram
+	interval time.Duration
+	clock    timex.Clock
+	proba    mathx.Decider
+
+	// the drop ratio is cached for an interval, since merging all histograms is not cheap.
+	ratioLock sync.Mutex
+	ratio     float64
+	ratioTime time.Duration
+}
+
+// NewLatencyBreaker returns a Breaker that starts shedding when the latency at quantile,
+// e.g. 0.99 for p99, goes over slo. The drop ratio is 1 - slo/latency.
+// Only the calls reported by LatencyPromise are taken into account.
+func NewLatencyBreaker(slo time.Duration, quantile float64, opts ...Option) Breaker {
+	if slo <= 0 {
+		panic("slo must be greater than 0")
+	}
+	if quantile <= 0 || quantile > 1 {
+		panic("quantile must be in (0, 1]")
+	}
+
+	return newCircuitBreaker(func(opts throttleOptions) internalThrottle {
+		b := newLatencyBreaker(slo, quantile, opts.clock)
+		b.proba = opts.newDecider()
+		return b
+	}, opts...)
+}
+
+func newLatencyBreaker(slo time.Duration, quantile float64, clock timex.Clock) *latencyBreaker {
+	interval := time.Duration(int64(window) / int64(buckets))
+	return &latencyBreaker{
+		slo:        slo,
+		quantile:   quantile,
+		protection: latencyProtection,
+		stat:       collection.NewRollingHistogram(buckets, interval, collection.WithRollingHistogramClock(clock)),
+		interval:   interval,
+		clock:      clock,
+		proba:      mathx.NewProba(),
+		ratioTime:  clock.Now() - interval,
+	}
+}
+
+func (b *latencyBreaker) allow() (internalPromise, error) {
+	if ratio := b.dropRatio(); ratio > 0 && b.proba.TrueOnProba(ratio) {
+		return nil, ErrServiceUnavailable
+	}
+
+	return b.promise(), nil
+}
+
+func (b *latencyBreaker) promise() internalPromise {
+	return latencyPromise{b: b}
+}
+
+func (b *latencyBreaker) dropRatio() float64 {
+	b.ratioLock.Lock()
+	defer b.ratioLock.Unlock()
+
+	now := b.clock.Now()
+	if now-b.ratioTime >= b.interval {
+		history := b.stat.Snapshot()
+		b.ratio = b.calcDropRatio(&history)
+		b.ratioTime = now
+	}
+
+	return b.ratio
+}
+
+func (b *latencyBreaker) calcDropRatio(history *collection.Histogram) float64 {
+	b.lock.RLock()
+	slo, quantile, protection := b.slo.Seconds(), b.quantile, b.protection
+	b.lock.RUnlock()
+
+	if history.Count < protection || history.Count == 0 {
+		return 0
+	}
+
+	latency := history.Quantile(quantile)
+	if latency <= slo {
+		return 0
+	}
+
+	return 1 - slo/latency
+}
+
+func (b *latencyBreaker) add(latency time.Duration) {
+	b.stat.Add(latency.Seconds())
+}
+
+func (b *latencyBreaker) stats() Stats {
+	history := b.stat.Snapshot()
+
+	b.lock.RLock()
+	slo := b.slo
+	params := map[string]float64{
+		paramSLO:        b.slo.Seconds(),
+		paramQuantile:   b.quantile,
+		paramProtection: float64(b.protection),
+	}
+	b.lock.RUnlock()
+
+	return Stats{
+		Accepts:   history.CountBelow(slo.Seconds()),
+		Total:     history.Count,
+		DropRatio: b.calcDropRatio(&history),
+		Params:    params,
+	}
+}
+
+func (b *latencyBreaker) reset() {
+	b.stat.Reset()
+
+	b.ratioLock.Lock()
+	b.ratio = 0
+	b.ratioTime = b.clock.Now() - b.interval
+	b.ratioLock.Unlock()
+}
+
+func (b *latencyBreaker) tune(param string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		return ErrInvalidParam
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch param {
+	case paramSLO:
+		b.slo = time.Duration(value * float64(time.Second))
+	case paramQuantile:
+		if value > 1 {
+			return ErrInvalidParam
+		}
+		b.quantile = value
+	case paramProtection:
+		b.protection = int64(value)
+	default:
+		return ErrUnknownParam
+	}
+
+	return nil
+}
+
+type latencyPromise struct {
+	b *latencyBreaker
+}
+
+// Accept is called without latency, nothing to record.
+func (p latencyPromise) Accept() {
+}
+
+// Reject is called without latency, nothing to record.
+func (p latencyPromise) Reject() {
+}
+
+func (p latencyPromise) acceptWithLatency(latency time.Duration) {
+	p.b.add(latency)
+}
+
+func (p latencyPromise) rejectWithLatency(latency time.Duration) {
+	p.b.add(latency)
+}
+
+func (p latencyPromise) stateless() {}
@@ -0,0 +1,144 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLatencyBreaker(t *testing.T) {
+	assert.Panics(t, func() {
+		NewLatencyBreaker(0, 0.99)
+	})
+	assert.Panics(t, func() {
+		NewLatencyBreaker(time.Second, 0)
+	})
+	assert.Panics(t, func() {
+		NewLatencyBreaker(time.Second, 1.1)
+	})
+
+	b := NewLatencyBreaker(time.Second, 0.99, WithName("latency"))
+	assert.Equal(t, "latency", b.Name())
+}
+
+func TestLatencyBreaker_Allow(t *testing.T) {
+	t.Run("under slo", func(t *testing.T) {
+		b := newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+		addLatency(b, 1000, time.Millisecond)
+		assert.Equal(t, float64(0), b.dropRatio())
+		verify(t, func() bool {
+			_, err := b.allow()
+			return err == nil
+		})
+	})
+
+	t.Run("over slo", func(t *testing.T) {
+		b := newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+		addLatency(b, 1000, time.Millisecond*100)
+		ratio := b.dropRatio()
+		assert.True(t, ratio >= 0.9 && ratio < 1, "actual %f", ratio)
+		verify(t, func() bool {
+			_, err := b.allow()
+			return err == ErrServiceUnavailable
+		})
+	})
+
+	t.Run("proportional", func(t *testing.T) {
+		b := newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+		addLatency(b, 1000, time.Millisecond*20)
+		ratio := b.dropRatio()
+		assert.True(t, ratio >= 0.5 && ratio < 0.7, "actual %f", ratio)
+	})
+
+	t.Run("quantile", func(t *testing.T) {
+		b := newLatencyBreaker(time.Millisecond*10, 0.99, timex.NewManualClock(0))
+		addLatency(b, 980, time.Millisecond)
+		addLatency(b, 20, time.Second)
+		assert.True(t, b.dropRatio() > 0.9)
+
+		b = newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+		addLatency(b, 980, time.Millisecond)
+		addLatency(b, 20, time.Second)
+		assert.Equal(t, float64(0), b.dropRatio())
+	})
+
+	t.Run("protection", func(t *testing.T) {
+		b := newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+		addLatency(b, latencyProtection-1, time.Second)
+		assert.Equal(t, float64(0), b.dropRatio())
+	})
+
+	t.Run("without latency", func(t *testing.T) {
+		b := newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+		for i := 0; i < 100; i++ {
+			p, err := b.allow()
+			assert.NoError(t, err)
+			p.Reject()
+		}
+		assert.Equal(t, int64(0), b.stat.Count())
+	})
+}
+
+func TestLatencyBreaker_Stats(t *testing.T) {
+	b := newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+	addLatency(b, 30, time.Millisecond)
+	addLatency(b, 10, time.Second)
+
+	stats := b.stats()
+	assert.Equal(t, int64(30), stats.Accepts)
+	assert.Equal(t, int64(40), stats.Total)
+	assert.True(t, stats.DropRatio > 0.9)
+	assert.Equal(t, 0.01, stats.Params[paramSLO])
+	assert.Equal(t, 0.95, stats.Params[paramQuantile])
+	assert.Equal(t, float64(latencyProtection), stats.Params[paramProtection])
+
+	b.reset()
+	stats = b.stats()
+	assert.Equal(t, int64(0), stats.Total)
+	assert.Equal(t, float64(0), b.dropRatio())
+}
+
+func TestLatencyBreaker_Tune(t *testing.T) {
+	b := newLatencyBreaker(time.Millisecond*10, 0.95, timex.NewManualClock(0))
+	addLatency(b, 100, time.Millisecond*100)
+	assert.True(t, b.stats().DropRatio > 0)
+
+	assert.NoError(t, b.tune(paramSLO, 1))
+	assert.NoError(t, b.tune(paramQuantile, 0.5))
+	assert.NoError(t, b.tune(paramProtection, 1000))
+	assert.ErrorIs(t, b.tune(paramQuantile, 2), ErrInvalidParam)
+	assert.ErrorIs(t, b.tune(paramSLO, 0), ErrInvalidParam)
+	assert.ErrorIs(t, b.tune("any", 1), ErrUnknownParam)
+
+	stats := b.stats()
+	assert.Equal(t, float64(1), stats.Params[paramSLO])
+	assert.Equal(t, 0.5, stats.Params[paramQuantile])
+	assert.Equal(t, float64(0), stats.DropRatio)
+}
+
+func TestLatencyBreaker_Promise(t *testing.T) {
+	b := NewLatencyBreaker(time.Millisecond*10, 0.95)
+	for i := 0; i < 100; i++ {
+		allow, err := b.Allow()
+		assert.NoError(t, err)
+		if i%2 == 0 {
+			allow.(LatencyPromise).AcceptWithLatency("query", time.Second)
+		} else {
+			allow.(LatencyPromise).RejectWithLatency("query", "any", time.Second)
+		}
+	}
+
+	stats := b.(Inspector).Stats()
+	assert.Equal(t, int64(100), stats.Total)
+	assert.Equal(t, int64(0), stats.Accepts)
+	assert.True(t, stats.DropRatio > 0.9)
+	assert.Equal(t, int64(100), stats.Latency.Count)
+}
+
+func addLatency(b *latencyBreaker, count int, latency time.Duration) {
+	for i := 0; i < count; i++ {
+		b.add(latency)
+	}
+}
@@ -0,0 +1,221 @@
+package breaker
+
+import (
+	"database/sql"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/mathx"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+const (
+	paramMaxPoolWait = "maxPoolWait"
+	paramMaxInUse    = "maxInUse"
+)
+
+type (
+	// PoolStats returns the statistics of a connection pool, e.g. the method value db.Stats of a *sql.DB.
+	PoolStats func() sql.DBStats
+
+	// poolBreaker sheds requests when the connection pool is about to be exhausted,
+	// that is when the mean wait for a connection goes over maxWait, or the ratio of connections in use over maxInUse.
+	// The drop ratio is proportional to how far it's over, like the latencyBreaker.
+	poolBreaker struct {
+		poolStats PoolStats
+
+		// lock guards maxWait and maxInUse, which can be tuned at runtime.
+		lock     sync.RWMutex
+		maxWait  time.Duration
+		maxInUse float64
+
+		interval time.Duration
+		clock    timex.Clock
+		proba    mathx.Decider
+		// admitted calls are counted as 1, the calls that are not as 0.
+		stat windowStat
+
+		// the drop ratio is sampled from the pool once an interval.
+		ratioLock sync.Mutex
+		ratio     float64
+		ratioTime time.Duration
+		// last is the last sample, the waits are counted since then.
+		last    sql.DBStats
+		sampled bool
+	}
+)
+
+// NewPoolBreaker returns a Breaker that sheds requests when the pool reported by stats is about to be exhausted:
+// when the mean time waited for a connection since the last sample goes over maxWait,
+// or when the ratio of connections in use to the max open connections goes over maxInUse.
+// The drop ratio is 1 - maxWait/wait or 1 - maxInUse/inUse, whichever is higher, a threshold of 0 disables the check.
+// The pool is sampled every 250ms, stats can return the zero sql.DBStats until the *sql.DB is open.
+func NewPoolBreaker(stats PoolStats, maxWait time.Duration, maxInUse float64, opts ...Option) Breaker {
+	checkPoolBreaker(stats, maxWait, maxInUse)
+
+	return newCircuitBreaker(func(opts throttleOptions) internalThrottle {
+		b := newPoolBreaker(stats, maxWait, maxInUse, opts.clock)
+		b.proba = opts.newDecider()
+		return b
+	}, opts...)
+}
+
+// WithPoolLimit returns a function to put a pool breaker in front of a Breaker, see NewPoolBreaker.
+func WithPoolLimit(stats PoolStats, maxWait time.Duration, maxInUse float64) Option {
+	checkPoolBreaker(stats, maxWait, maxInUse)
+
+	return func(b *circuitBreaker) {
+		b.front = append(b.front, func(opts throttleOptions) internalThrottle {
+			pb := newPoolBreaker(stats, maxWait, maxInUse, opts.clock)
+			pb.proba = opts.newDecider()
+			return pb
+		})
+	}
+}
+
+func checkPoolBreaker(stats PoolStats, maxWait time.Duration, maxInUse float64) {
+	if stats == nil {
+		panic("stats must not be nil")
+	}
+	if maxWait < 0 {
+		panic("maxWait must not be negative")
+	}
+	if maxInUse < 0 || maxInUse > 1 {
+		panic("maxInUse must be in [0, 1]")
+	}
+}
+
+func newPoolBreaker(stats PoolStats, maxWait time.Duration, maxInUse float64, clock timex.Clock) *poolBreaker {
+	interval := time.Duration(int64(window) / int64(buckets))
+	return &poolBreaker{
+		poolStats: stats,
+		maxWait:   maxWait,
+		maxInUse:  maxInUse,
+		interval:  interval,
+		clock:     clock,
+		proba:     mathx.NewProba(),
+		stat: windowStat{collection.NewAtomicRollingWindow(buckets, interval,
+			collection.WithAtomicRollingWindowClock(clock))},
+		ratioTime: clock.Now() - interval,
+	}
+}
+
+func (b *poolBreaker) allow() (internalPromise, error) {
+	if ratio := b.dropRatio(); ratio > 0 && b.proba.TrueOnProba(ratio) {
+		b.stat.add(0)
+		return nil, ErrServiceUnavailable
+	}
+
+	b.stat.add(1)
+	return b.promise(), nil
+}
+
+func (b *poolBreaker) promise() internalPromise {
+	return poolPromise{}
+}
+
+func (b *poolBreaker) dropRatio() float64 {
+	b.ratioLock.Lock()
+	defer b.ratioLock.Unlock()
+
+	now := b.clock.Now()
+	if now-b.ratioTime >= b.interval {
+		stats := b.poolStats()
+		b.ratio = b.calcDropRatio(b.last, stats, b.sampled)
+		b.last = stats
+		b.sampled = true
+		b.ratioTime = now
+	}
+
+	return b.ratio
+}
+
+// calcDropRatio calculates the drop ratio with the waits from last to current, which are ignored if not sampled.
+func (b *poolBreaker) calcDropRatio(last, current sql.DBStats, sampled bool) float64 {
+	b.lock.RLock()
+	maxWait, maxInUse := b.maxWait, b.maxInUse
+	b.lock.RUnlock()
+
+	var ratio float64
+	if waits := current.WaitCount - last.WaitCount; maxWait > 0 && sampled && waits > 0 {
+		wait := (current.WaitDuration - last.WaitDuration) / time.Duration(waits)
+		if wait > maxWait {
+			ratio = 1 - float64(maxWait)/float64(wait)
+		}
+	}
+
+	if maxInUse > 0 && current.MaxOpenConnections > 0 {
+		inUse := float64(current.InUse) / float64(current.MaxOpenConnections)
+		if inUse > maxInUse {
+			ratio = math.Max(ratio, 1-maxInUse/inUse)
+		}
+	}
+
+	return ratio
+}
+
+func (b *poolBreaker) stats() Stats {
+	accepts, total := b.stat.history()
+
+	b.lock.RLock()
+	params := map[string]float64{
+		paramMaxPoolWait: b.maxWait.Seconds(),
+		paramMaxInUse:    b.maxInUse,
+	}
+	b.lock.RUnlock()
+
+	b.ratioLock.Lock()
+	ratio := b.ratio
+	b.ratioLock.Unlock()
+
+	return Stats{
+		Accepts:   int64(accepts),
+		Total:     int64(total),
+		DropRatio: ratio,
+		Params:    params,
+	}
+}
+
+func (b *poolBreaker) reset() {
+	b.stat.reset()
+
+	b.ratioLock.Lock()
+	b.ratio = 0
+	b.ratioTime = b.clock.Now() - b.interval
+	b.sampled = false
+	b.ratioLock.Unlock()
+}
+
+func (b *poolBreaker) tune(param string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return ErrInvalidParam
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch param {
+	case paramMaxPoolWait:
+		b.maxWait = time.Duration(value * float64(time.Second))
+	case paramMaxInUse:
+		if value > 1 {
+			return ErrInvalidParam
+		}
+		b.maxInUse = value
+	default:
+		return ErrUnknownParam
+	}
+
+	return nil
+}
+
+// poolPromise holds nothing, the pool is watched by sampling.
+type poolPromise struct{}
+
+func (poolPromise) Accept() {}
+
+func (poolPromise) Reject() {}
+
+func (poolPromise) stateless() {}
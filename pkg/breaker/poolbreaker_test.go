@@ -0,0 +1,131 @@
+package breaker
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ PoolStats = new(sql.DB).Stats
+
+// certainDecider decides true on any positive probability.
+type certainDecider struct{}
+
+func (certainDecider) TrueOnProba(proba float64) bool {
+	return proba > 0
+}
+
+type mockedPool struct {
+	lock  sync.Mutex
+	stats sql.DBStats
+}
+
+func (p *mockedPool) Stats() sql.DBStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
+
+func (p *mockedPool) set(fn func(stats *sql.DBStats)) {
+	p.lock.Lock()
+	fn(&p.stats)
+	p.lock.Unlock()
+}
+
+func TestNewPoolBreaker(t *testing.T) {
+	pool := new(mockedPool)
+	assert.Panics(t, func() {
+		NewPoolBreaker(nil, time.Second, 0.9)
+	})
+	assert.Panics(t, func() {
+		NewPoolBreaker(pool.Stats, -1, 0.9)
+	})
+	assert.Panics(t, func() {
+		WithPoolLimit(pool.Stats, time.Second, 1.5)
+	})
+
+	b := NewPoolBreaker(pool.Stats, time.Second, 0.9, WithName("pool"))
+	assert.Equal(t, "pool", b.Name())
+}
+
+func TestPoolBreaker_CalcDropRatio(t *testing.T) {
+	b := newPoolBreaker(new(mockedPool).Stats, time.Millisecond*10, 0.8, timex.NewManualClock(0))
+	last := sql.DBStats{MaxOpenConnections: 10, WaitCount: 10, WaitDuration: time.Second}
+
+	current := last
+	current.InUse = 8
+	assert.Equal(t, float64(0), b.calcDropRatio(last, current, true))
+
+	// 10 waits of 40ms on average since the last sample.
+	current.WaitCount = 20
+	current.WaitDuration = time.Second + time.Millisecond*400
+	assert.InDelta(t, 0.75, b.calcDropRatio(last, current, true), 1e-9)
+	assert.Equal(t, float64(0), b.calcDropRatio(last, current, false))
+
+	current.InUse = 10
+	assert.InDelta(t, 0.75, b.calcDropRatio(last, current, true), 1e-9)
+	assert.InDelta(t, 0.2, b.calcDropRatio(last, current, false), 1e-9)
+
+	// unlimited pools have no ratio in use.
+	current.MaxOpenConnections = 0
+	assert.Equal(t, float64(0), b.calcDropRatio(last, current, false))
+}
+
+func TestPoolBreaker_Allow(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	pool := new(mockedPool)
+	pool.set(func(stats *sql.DBStats) {
+		stats.MaxOpenConnections = 10
+		stats.InUse = 10
+	})
+	b := newPoolBreaker(pool.Stats, 0, 0.5, clock)
+	b.proba = certainDecider{}
+
+	_, err := b.allow()
+	assert.ErrorIs(t, err, ErrServiceUnavailable)
+	assert.Equal(t, 0.5, b.stats().DropRatio)
+
+	// the pool is sampled once an interval.
+	pool.set(func(stats *sql.DBStats) {
+		stats.InUse = 1
+	})
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrServiceUnavailable)
+	clock.Advance(b.interval)
+	p, err := b.allow()
+	assert.NoError(t, err)
+	p.Accept()
+
+	stats := b.stats()
+	assert.Equal(t, int64(1), stats.Accepts)
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, float64(0), stats.DropRatio)
+	assert.Equal(t, 0.5, stats.Params[paramMaxInUse])
+
+	b.reset()
+	assert.Equal(t, int64(0), b.stats().Total)
+}
+
+func TestPoolBreaker_Tune(t *testing.T) {
+	b := newPoolBreaker(new(mockedPool).Stats, time.Second, 0.9, timex.NewManualClock(0))
+	assert.NoError(t, b.tune(paramMaxPoolWait, 0.05))
+	assert.NoError(t, b.tune(paramMaxInUse, 0))
+	assert.ErrorIs(t, b.tune(paramMaxInUse, 2), ErrInvalidParam)
+	assert.ErrorIs(t, b.tune(paramMaxPoolWait, -1), ErrInvalidParam)
+	assert.ErrorIs(t, b.tune("any", 1), ErrUnknownParam)
+	assert.Equal(t, time.Millisecond*50, b.maxWait)
+	assert.Equal(t, float64(0), b.maxInUse)
+}
+
+func TestWithPoolLimit(t *testing.T) {
+	pool := new(mockedPool)
+	b := NewBreaker(WithPoolLimit(pool.Stats, time.Second, 0.9)).(*circuitBreaker)
+	p, err := b.Allow()
+	assert.NoError(t, err)
+	p.Accept()
+	assert.Equal(t, 0.9, b.Stats().Params[paramMaxInUse])
+}
@@ -0,0 +1,189 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+const (
+	// OpProbe is the operation kind of the health probes in the latency statistics.
+	OpProbe = "probe"
+
+	defaultRecoverAfter = 3
+)
+
+type (
+	// ProbeFunc checks the health of the database, e.g. by running SELECT 1 over a dedicated connection.
+	ProbeFunc func(ctx context.Context) error
+
+	// ProberOption defines the method to customize a Prober.
+	ProberOption func(p *Prober)
+
+	// A Prober runs a health probe on an interval while its Breaker is shedding requests,
+	// the results feed the statistics of the Breaker as if they were requests,
+	// and enough successes in a row clear the statistics, which closes the Breaker early,
+	// through the slow start if enabled, see WithSlowStart.
+	// It probes nothing until Start is called, and Stop must be called to release its goroutine.
+	Prober struct {
+		brk          probeTarget
+		probe        ProbeFunc
+		interval     time.Duration
+		timeout      time.Duration
+		recoverAfter int
+		// successes is the number of successful probes in a row.
+		successes int64
+
+		// lock guards cancel and done, which are set while running.
+		lock   sync.Mutex
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+
+	// probeTarget is implemented by the breakers that take probes into account.
+	probeTarget interface {
+		Inspector
+		// probePromise returns a promise for a probe, which is not checked by the breaker.
+		probePromise() Promise
+		// recover clears the statistics of the requests unless the state is forced,
+		// the Breaker ramps up by the slow start if enabled.
+		recover()
+	}
+)
+
+// NewProber returns a Prober that runs probe every interval while brk is shedding requests.
+// brk must be created by this package, e.g. by NewBreaker.
+// Use opts to customize the Prober.
+func NewProber(brk Breaker, probe ProbeFunc, interval time.Duration, opts ...ProberOption) *Prober {
+	target, ok := brk.(probeTarget)
+	if !ok {
+		panic("brk must be created by the breaker package")
+	}
+	if probe == nil {
+		panic("probe must not be nil")
+	}
+	if interval <= 0 {
+		panic("interval must be greater than 0")
+	}
+
+	p := &Prober{
+		brk:          target,
+		probe:        probe,
+		interval:     interval,
+		timeout:      interval,
+		recoverAfter: defaultRecoverAfter,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// WithProbeTimeout returns a ProberOption to cancel the context of a probe after timeout, the interval by default.
+func WithProbeTimeout(timeout time.Duration) ProberOption {
+	return func(p *Prober) {
+		p.timeout = timeout
+	}
+}
+
+// WithRecoverAfter returns a ProberOption to clear the statistics of the Breaker after n successful probes in a row,
+// 3 by default, 0 leaves the Breaker to recover by the statistics.
+func WithRecoverAfter(n int) ProberOption {
+	return func(p *Prober) {
+		p.recoverAfter = n
+	}
+}
+
+// Start starts probing in a goroutine, it does nothing if already started.
+func (p *Prober) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.done != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.run(ctx, p.done)
+}
+
+// Stop stops probing and waits for the goroutine to exit, the running probe is canceled.
+// It does nothing if not started, and the Prober can be started again.
+func (p *Prober) Stop() {
+	p.lock.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.lock.Unlock()
+
+	if done == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+func (p *Prober) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check(ctx)
+		}
+	}
+}
+
+// check runs the probe if the Breaker is shedding requests, and feeds the result to the Breaker.
+// The drops of the slow start are not shedding, probing would cut the ramp short.
+func (p *Prober) check(ctx context.Context) {
+	if stats := p.brk.Stats(); stats.State != StateAuto || stats.DropRatio <= 0 || stats.Warmup > 0 {
+		atomic.StoreInt64(&p.successes, 0)
+		return
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := timex.Now()
+	err := p.probe(probeCtx)
+	latency := timex.Since(start)
+	if ctx.Err() != nil {
+		// stopped, the result tells nothing about the database.
+		return
+	}
+
+	// the promise is taken after the probe, which runs over its own connection and holds no slot.
+	promise := p.brk.probePromise()
+	lp, _ := promise.(LatencyPromise)
+	if err != nil {
+		atomic.StoreInt64(&p.successes, 0)
+		if lp != nil {
+			lp.RejectWithLatency(OpProbe, "probe: "+err.Error(), latency)
+		} else {
+			promise.Reject("probe: " + err.Error())
+		}
+		return
+	}
+
+	if lp != nil {
+		lp.AcceptWithLatency(OpProbe, latency)
+	} else {
+		promise.Accept()
+	}
+	if successes := atomic.AddInt64(&p.successes, 1); p.recoverAfter > 0 && successes >= int64(p.recoverAfter) {
+		atomic.StoreInt64(&p.successes, 0)
+		p.brk.recover()
+	}
+}
@@ -0,0 +1,157 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+// sheddingBreaker returns a Breaker that is shedding requests, as the time doesn't go by.
+func sheddingBreaker(t *testing.T) *circuitBreaker {
+	b := NewBreaker(WithClock(timex.NewManualClock(0))).(*circuitBreaker)
+	for i := 0; i < 100; i++ {
+		if p, err := b.Allow(); err == nil {
+			p.Reject("fail")
+		}
+	}
+	assert.True(t, b.Stats().DropRatio > 0)
+
+	return b
+}
+
+func TestNewProber(t *testing.T) {
+	probe := func(context.Context) error {
+		return nil
+	}
+	assert.Panics(t, func() {
+		NewProber(struct{ Breaker }{}, probe, time.Second)
+	})
+	assert.Panics(t, func() {
+		NewProber(NewBreaker(), nil, time.Second)
+	})
+	assert.Panics(t, func() {
+		NewProber(NewBreaker(), probe, 0)
+	})
+
+	p := NewProber(NewBreaker(), probe, time.Second, WithProbeTimeout(time.Millisecond), WithRecoverAfter(1))
+	assert.Equal(t, time.Millisecond, p.timeout)
+	assert.Equal(t, 1, p.recoverAfter)
+}
+
+func TestProber_Check(t *testing.T) {
+	b := sheddingBreaker(t)
+	var healthy atomic.Value
+	healthy.Store(false)
+	p := NewProber(b, func(ctx context.Context) error {
+		if healthy.Load().(bool) {
+			return nil
+		}
+		return errors.New("down")
+	}, time.Second)
+
+	total := b.Stats().Total
+	p.check(context.Background())
+	stats := b.Stats()
+	assert.Equal(t, total+1, stats.Total)
+	assert.Contains(t, stats.Reasons[0], "probe: down")
+	assert.Equal(t, int64(1), stats.OpLatency[OpProbe].Count)
+
+	healthy.Store(true)
+	p.check(context.Background())
+	p.check(context.Background())
+	assert.True(t, b.Stats().DropRatio > 0)
+	// the third success in a row closes the breaker.
+	p.check(context.Background())
+	stats = b.Stats()
+	assert.Equal(t, float64(0), stats.DropRatio)
+	assert.Equal(t, int64(0), stats.Total)
+}
+
+func TestProber_SlowStart(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := NewBreaker(WithClock(clock), WithSlowStart(time.Second*10, RampLinear), WithErrorDiffusion()).(*circuitBreaker)
+	for i := 0; i < 100; i++ {
+		if p, err := b.Allow(); err == nil {
+			p.Reject("fail")
+		}
+	}
+	var probes int64
+	p := NewProber(b, func(context.Context) error {
+		atomic.AddInt64(&probes, 1)
+		return nil
+	}, time.Second, WithRecoverAfter(1))
+
+	// the breaker closes early, but ramps up rather than admitting all at once.
+	p.check(context.Background())
+	stats := b.Stats()
+	assert.True(t, stats.Warmup > 0)
+	assert.InDelta(t, 0.9, stats.DropRatio, 1e-9)
+
+	// the ramp is not probed.
+	clock.Advance(time.Second)
+	p.check(context.Background())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&probes))
+	stats = b.Stats()
+	assert.InDelta(t, 0.1, stats.Warmup, 1e-9)
+	assert.InDelta(t, 0.81, stats.DropRatio, 1e-9)
+
+	clock.Advance(time.Second * 9)
+	assert.Equal(t, float64(0), b.Stats().DropRatio)
+}
+
+func TestProber_CheckNotShedding(t *testing.T) {
+	var probes int64
+	probe := func(context.Context) error {
+		atomic.AddInt64(&probes, 1)
+		return nil
+	}
+
+	NewProber(NewBreaker(), probe, time.Second).check(context.Background())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&probes))
+
+	// the forced states are left alone.
+	b := sheddingBreaker(t)
+	b.Force(StateForcedOpen)
+	NewProber(b, probe, time.Second).check(context.Background())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&probes))
+}
+
+func TestProber_StartStop(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	b := sheddingBreaker(t)
+	started := make(chan struct{}, 1)
+	p := NewProber(b, func(ctx context.Context) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// hangs until canceled.
+		<-ctx.Done()
+		return ctx.Err()
+	}, time.Millisecond, WithProbeTimeout(time.Hour))
+
+	p.Stop()
+	p.Start()
+	p.Start()
+	<-started
+	total := b.Stats().Total
+	p.Stop()
+	p.Stop()
+	// the canceled probe is not recorded.
+	assert.Equal(t, total, b.Stats().Total)
+
+	p.Start()
+	<-started
+	p.Stop()
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+}
@@ -0,0 +1,308 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/collection"
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+const (
+	paramRate  = "rate"
+	paramBurst = "burst"
+)
+
+// ErrRateLimited is matched by the errors returned when a rate limit is exceeded, see RateLimitError.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+type (
+	// A RateLimitError is returned when a call exceeds a rate limit, errors.Is(err, ErrRateLimited) reports true for it.
+	RateLimitError struct {
+		// Key is the key whose limit is exceeded, empty for the limit of all calls.
+		Key string
+		// RetryAfter is how long it takes for the limit to admit a call again.
+		RetryAfter time.Duration
+	}
+
+	// rateLimit is the rate in calls per second and the burst of a token bucket.
+	rateLimit struct {
+		rate  float64
+		burst float64
+	}
+
+	tokenBucket struct {
+		rateLimit
+		tokens float64
+		last   time.Duration
+	}
+
+	// rateLimiter admits the calls with token buckets, one for all calls and one for each key that has its limit.
+	rateLimiter struct {
+		// lock guards bucket and keys.
+		lock   sync.Mutex
+		bucket tokenBucket
+		keys   map[string]*keyBucket
+
+		clock timex.Clock
+		// admitted calls are counted as 1, the calls that are not as 0.
+		stat windowStat
+	}
+
+	// keyBucket is the token bucket of a key, which points back to its limiter for the promises.
+	keyBucket struct {
+		tokenBucket
+		l *rateLimiter
+	}
+
+	// rateLimitPromise refers to the limiter to give the token back if canceled, the tokens are taken on admission.
+	rateLimitPromise struct {
+		l *rateLimiter
+	}
+
+	// keyRateLimitPromise is the rateLimitPromise of a call that took a token of its key as well.
+	keyRateLimitPromise struct {
+		b *keyBucket
+	}
+)
+
+// NewRateLimiter returns a Breaker that admits rate calls per second on average, and up to burst calls at once.
+// The calls over the limit fail with a *RateLimitError. Use WithKeyRateLimit to limit the calls of some keys further,
+// and WithRateLimit to put a rate limiter in front of another Breaker.
+func NewRateLimiter(rate float64, burst int, opts ...Option) Breaker {
+	checkRateLimit(rate, burst)
+
+	return newCircuitBreaker(func(opts throttleOptions) internalThrottle {
+		return newRateLimiter(rateLimit{rate: rate, burst: float64(burst)}, opts.keyRates, opts.clock)
+	}, opts...)
+}
+
+// WithRateLimit returns a function to put a rate limiter in front of a Breaker, see NewRateLimiter.
+func WithRateLimit(rate float64, burst int) Option {
+	checkRateLimit(rate, burst)
+
+	return func(b *circuitBreaker) {
+		b.front = append(b.front, func(opts throttleOptions) internalThrottle {
+			return newRateLimiter(rateLimit{rate: rate, burst: float64(burst)}, opts.keyRates, opts.clock)
+		})
+	}
+}
+
+// WithKeyRateLimit returns a function to limit the calls allowed with key by KeyedBreaker.AllowKey
+// to rate calls per second and burst calls at once, on top of the limit of all calls.
+// It only takes effect on the rate limiters, see NewRateLimiter and WithRateLimit.
+func WithKeyRateLimit(key string, rate float64, burst int) Option {
+	checkRateLimit(rate, burst)
+
+	return func(b *circuitBreaker) {
+		if b.keyRates == nil {
+			b.keyRates = make(map[string]rateLimit)
+		}
+		b.keyRates[key] = rateLimit{rate: rate, burst: float64(burst)}
+	}
+}
+
+func checkRateLimit(rate float64, burst int) {
+	if math.IsNaN(rate) || math.IsInf(rate, 0) || rate <= 0 {
+		panic("rate must be greater than 0")
+	}
+	if burst < 1 {
+		panic("burst must be greater than 0")
+	}
+}
+
+func newRateLimiter(limit rateLimit, keyRates map[string]rateLimit, clock timex.Clock) *rateLimiter {
+	bucketDuration := time.Duration(int64(window) / int64(buckets))
+	now := clock.Now()
+	l := &rateLimiter{
+		bucket: newTokenBucket(limit, now),
+		keys:   make(map[string]*keyBucket, len(keyRates)),
+		clock:  clock,
+		stat: windowStat{collection.NewAtomicRollingWindow(buckets, bucketDuration,
+			collection.WithAtomicRollingWindowClock(clock))},
+	}
+	for key, limit := range keyRates {
+		l.keys[key] = &keyBucket{tokenBucket: newTokenBucket(limit, now), l: l}
+	}
+
+	return l
+}
+
+func (l *rateLimiter) allow() (internalPromise, error) {
+	return l.allowKey("")
+}
+
+func (l *rateLimiter) allowKey(key string) (internalPromise, error) {
+	kb, err := l.take(key)
+	if err != nil {
+		l.stat.add(0)
+		return nil, err
+	}
+
+	l.stat.add(1)
+	if kb != nil {
+		return keyRateLimitPromise{b: kb}, nil
+	}
+
+	return rateLimitPromise{l: l}, nil
+}
+
+// take takes a token from the bucket of key if any, and one from the bucket of all calls.
+// It returns the bucket of key, nil if none.
+func (l *rateLimiter) take(key string) (*keyBucket, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	kb, ok := l.keys[key]
+	if ok {
+		if wait := kb.take(now); wait > 0 {
+			return nil, &RateLimitError{Key: key, RetryAfter: wait}
+		}
+	}
+
+	if wait := l.bucket.take(now); wait > 0 {
+		if ok {
+			kb.tokens++
+		}
+		return nil, &RateLimitError{RetryAfter: wait}
+	}
+
+	return kb, nil
+}
+
+// refund gives back the tokens taken by a call, kb is the bucket of its key, nil if none.
+func (l *rateLimiter) refund(kb *keyBucket) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if kb != nil {
+		kb.tokens = math.Min(kb.burst, kb.tokens+1)
+	}
+	l.bucket.tokens = math.Min(l.bucket.burst, l.bucket.tokens+1)
+}
+
+// promise takes no token, the calls forced through don't count against the limit.
+func (l *rateLimiter) promise() internalPromise {
+	return rateLimitPromise{l: l}
+}
+
+func (l *rateLimiter) stats() Stats {
+	accepts, total := l.stat.history()
+
+	l.lock.Lock()
+	params := map[string]float64{
+		paramRate:  l.bucket.rate,
+		paramBurst: l.bucket.burst,
+	}
+	l.lock.Unlock()
+
+	var dropRatio float64
+	if total > 0 {
+		dropRatio = 1 - accepts/total
+	}
+
+	return Stats{
+		Accepts:   int64(accepts),
+		Total:     int64(total),
+		DropRatio: dropRatio,
+		Params:    params,
+	}
+}
+
+// reset clears the statistics, the tokens are kept.
+func (l *rateLimiter) reset() {
+	l.stat.reset()
+}
+
+// tune changes the limit of all calls.
+func (l *rateLimiter) tune(param string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		return ErrInvalidParam
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// fill the bucket at the old rate up to now.
+	l.bucket.fill(l.clock.Now())
+	switch param {
+	case paramRate:
+		l.bucket.rate = value
+	case paramBurst:
+		if value < 1 {
+			return ErrInvalidParam
+		}
+		l.bucket.burst = math.Floor(value)
+		l.bucket.tokens = math.Min(l.bucket.tokens, l.bucket.burst)
+	default:
+		return ErrUnknownParam
+	}
+
+	return nil
+}
+
+func newTokenBucket(limit rateLimit, now time.Duration) tokenBucket {
+	return tokenBucket{
+		rateLimit: limit,
+		tokens:    limit.burst,
+		last:      now,
+	}
+}
+
+// take takes a token if any and returns 0, otherwise it returns how long it takes to have one.
+func (b *tokenBucket) take(now time.Duration) time.Duration {
+	b.fill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+func (b *tokenBucket) fill(now time.Duration) {
+	if elapsed := now - b.last; elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (e *RateLimitError) Error() string {
+	if len(e.Key) == 0 {
+		return fmt.Sprintf("%s, retry after %s", ErrRateLimited, e.RetryAfter)
+	}
+
+	return fmt.Sprintf("%s for %q, retry after %s", ErrRateLimited, e.Key, e.RetryAfter)
+}
+
+// Is reports whether target is ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+func (rateLimitPromise) Accept() {}
+
+func (rateLimitPromise) Reject() {}
+
+// stateless tells that the promise can be shared, as only cancel uses the limiter.
+func (rateLimitPromise) stateless() {}
+
+// cancel gives the token back if the call is dropped by a throttle behind.
+func (p rateLimitPromise) cancel() {
+	p.l.refund(nil)
+}
+
+func (keyRateLimitPromise) Accept() {}
+
+func (keyRateLimitPromise) Reject() {}
+
+func (keyRateLimitPromise) stateless() {}
+
+// cancel gives the tokens back if the call is dropped by a throttle behind.
+func (p keyRateLimitPromise) cancel() {
+	p.b.l.refund(p.b)
+}
@@ -0,0 +1,174 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	assert.Panics(t, func() {
+		NewRateLimiter(0, 1)
+	})
+	assert.Panics(t, func() {
+		NewRateLimiter(1, 0)
+	})
+	assert.Panics(t, func() {
+		WithRateLimit(-1, 1)
+	})
+	assert.Panics(t, func() {
+		WithKeyRateLimit("key", 1, 0)
+	})
+
+	b := NewRateLimiter(1, 1, WithName("limiter"))
+	assert.Equal(t, "limiter", b.Name())
+	_, ok := b.(KeyedBreaker)
+	assert.True(t, ok)
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	l := newRateLimiter(rateLimit{rate: 10, burst: 2}, nil, clock)
+	for i := 0; i < 2; i++ {
+		p, err := l.allow()
+		assert.NoError(t, err)
+		p.Accept()
+	}
+
+	_, err := l.allow()
+	assert.ErrorIs(t, err, ErrRateLimited)
+	var rateErr *RateLimitError
+	assert.True(t, errors.As(err, &rateErr))
+	assert.Equal(t, "", rateErr.Key)
+	assert.Equal(t, time.Millisecond*100, rateErr.RetryAfter)
+
+	clock.Advance(time.Millisecond * 50)
+	_, err = l.allow()
+	assert.True(t, errors.As(err, &rateErr))
+	assert.Equal(t, time.Millisecond*50, rateErr.RetryAfter)
+
+	clock.Advance(time.Millisecond * 50)
+	_, err = l.allow()
+	assert.NoError(t, err)
+
+	// the bucket doesn't fill over burst.
+	clock.Advance(time.Second)
+	for i := 0; i < 2; i++ {
+		_, err = l.allow()
+		assert.NoError(t, err)
+	}
+	_, err = l.allow()
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	stats := l.stats()
+	assert.Equal(t, int64(5), stats.Accepts)
+	assert.Equal(t, int64(8), stats.Total)
+	assert.Equal(t, 0.375, stats.DropRatio)
+	assert.Equal(t, float64(10), stats.Params[paramRate])
+	assert.Equal(t, float64(2), stats.Params[paramBurst])
+
+	l.reset()
+	assert.Equal(t, int64(0), l.stats().Total)
+}
+
+func TestRateLimiter_AllowKey(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	l := newRateLimiter(rateLimit{rate: 1, burst: 2}, map[string]rateLimit{
+		"a": {rate: 1, burst: 1},
+	}, clock)
+
+	_, err := l.allowKey("a")
+	assert.NoError(t, err)
+	_, err = l.allowKey("a")
+	var rateErr *RateLimitError
+	assert.True(t, errors.As(err, &rateErr))
+	assert.Equal(t, "a", rateErr.Key)
+
+	// the keys without limits share the limit of all calls.
+	_, err = l.allowKey("b")
+	assert.NoError(t, err)
+	_, err = l.allow()
+	assert.True(t, errors.As(err, &rateErr))
+	assert.Equal(t, "", rateErr.Key)
+
+	// the token of the key is given back if the limit of all calls is exceeded.
+	clock.Advance(time.Second)
+	_, err = l.allowKey("b")
+	assert.NoError(t, err)
+	_, err = l.allowKey("a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, float64(1), l.keys["a"].tokens)
+}
+
+func TestRateLimiter_Cancel(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	front := newRateLimiter(rateLimit{rate: 1, burst: 2}, map[string]rateLimit{
+		"a": {rate: 1, burst: 2},
+	}, clock)
+	behind := newRateLimiter(rateLimit{rate: 1, burst: 1}, nil, clock)
+	c := newChainThrottle(front, behind)
+
+	p, err := c.allowKey("a")
+	assert.NoError(t, err)
+	p.Accept()
+	// the tokens of the calls that the limiter behind drops are given back.
+	for i := 0; i < 3; i++ {
+		_, err = c.allowKey("a")
+		assert.ErrorIs(t, err, ErrRateLimited)
+		assert.Equal(t, float64(1), front.bucket.tokens)
+		assert.Equal(t, float64(1), front.keys["a"].tokens)
+	}
+
+	_, err = c.allow()
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, float64(1), front.bucket.tokens)
+
+	// the tokens given back don't fill the buckets over burst.
+	front.refund(front.keys["a"])
+	front.refund(front.keys["a"])
+	assert.Equal(t, float64(2), front.bucket.tokens)
+	assert.Equal(t, float64(2), front.keys["a"].tokens)
+}
+
+func TestRateLimiter_Tune(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	l := newRateLimiter(rateLimit{rate: 1, burst: 4}, nil, clock)
+	assert.NoError(t, l.tune(paramBurst, 2.5))
+	assert.Equal(t, float64(2), l.bucket.tokens)
+	assert.NoError(t, l.tune(paramRate, 100))
+	assert.ErrorIs(t, l.tune(paramRate, 0), ErrInvalidParam)
+	assert.ErrorIs(t, l.tune(paramBurst, 0.5), ErrInvalidParam)
+	assert.ErrorIs(t, l.tune("any", 1), ErrUnknownParam)
+
+	for i := 0; i < 2; i++ {
+		_, err := l.allow()
+		assert.NoError(t, err)
+	}
+	clock.Advance(time.Millisecond * 10)
+	_, err := l.allow()
+	assert.NoError(t, err)
+}
+
+func TestWithRateLimit(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	b := NewBreaker(WithRateLimit(1, 1), WithKeyRateLimit("a", 1, 1), WithClock(clock)).(*circuitBreaker)
+	p, err := b.AllowKey("a")
+	assert.NoError(t, err)
+	p.Accept()
+	_, err = b.AllowKey("a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	stats := b.Stats()
+	assert.Equal(t, float64(1), stats.Params[paramRate])
+	assert.Equal(t, int64(1), stats.Accepts)
+	assert.NoError(t, b.Tune(paramRate, 2))
+
+	b.Force(StateForcedClosed)
+	_, err = b.AllowKey("a")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,145 @@
+package collection
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+// maxHistogramStripes caps the stripes of an AtomicRollingHistogram, which are much larger than the ones of a window.
+const maxHistogramStripes = 8
+
+type (
+	// AtomicRollingHistogramOption let callers customize the AtomicRollingHistogram.
+	AtomicRollingHistogramOption func(rollingHistogram *AtomicRollingHistogram)
+
+	// AtomicRollingHistogram is a RollingHistogram that doesn't take locks,
+	// it's meant for the hot paths that are called from many goroutines.
+	// The histograms are aligned, sharded and replaced like the buckets of AtomicRollingWindow.
+	AtomicRollingHistogram struct {
+		size    int64
+		stripes *stripes
+		// slots hold the *atomicHistogram of each stripe and histogram, stripe by stripe, nil if not used.
+		slots    []unsafe.Pointer
+		interval time.Duration
+		clock    timex.Clock
+	}
+
+	atomicHistogram struct {
+		// epoch is the index of the interval the histogram counts for, which never changes.
+		epoch int64
+		// sum and max are the bits of float64 values.
+		sum    uint64
+		max    uint64
+		counts [histogramBuckets]int64
+	}
+)
+
+// NewAtomicRollingHistogram returns an AtomicRollingHistogram that with size histograms and time interval,
+// use opts to customize the AtomicRollingHistogram.
+func NewAtomicRollingHistogram(size int, interval time.Duration,
+	opts ...AtomicRollingHistogramOption) *AtomicRollingHistogram {
+	if size < 1 {
+		panic("size must be greater than 0")
+	}
+
+	stripes := newStripes(maxHistogramStripes)
+	h := &AtomicRollingHistogram{
+		size:     int64(size),
+		stripes:  stripes,
+		slots:    make([]unsafe.Pointer, size*stripes.n),
+		interval: interval,
+		clock:    timex.RealClock(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// Add adds value to current histogram, NaN and infinities are dropped.
+func (rh *AtomicRollingHistogram) Add(v float64) {
+	if !finite(v) {
+		return
+	}
+
+	epoch := rh.epoch()
+	stripe := rh.stripes.get()
+	h := rh.histogram(&rh.slots[int64(*stripe)*rh.size+epoch%rh.size], epoch)
+	rh.stripes.put(stripe)
+
+	atomic.AddInt64(&h.counts[histogramIndex(v)], 1)
+	for {
+		old := atomic.LoadUint64(&h.sum)
+		if atomic.CompareAndSwapUint64(&h.sum, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	for {
+		old := atomic.LoadUint64(&h.max)
+		if v <= math.Float64frombits(old) || atomic.CompareAndSwapUint64(&h.max, old, math.Float64bits(v)) {
+			break
+		}
+	}
+}
+
+// histogram returns the histogram of epoch in slot, which replaces the expired one.
+func (rh *AtomicRollingHistogram) histogram(slot *unsafe.Pointer, epoch int64) *atomicHistogram {
+	for {
+		p := atomic.LoadPointer(slot)
+		// a newer histogram means the clock moved on meanwhile, the value counts for the newer interval.
+		if h := (*atomicHistogram)(p); h != nil && h.epoch >= epoch {
+			return h
+		}
+
+		h := &atomicHistogram{epoch: epoch}
+		if atomic.CompareAndSwapPointer(slot, p, unsafe.Pointer(h)) {
+			return h
+		}
+	}
+}
+
+// Snapshot returns a Histogram that merges all histograms that are not expired.
+// The values added meanwhile might be counted or not, the Count always matches the buckets.
+func (rh *AtomicRollingHistogram) Snapshot() Histogram {
+	var merged Histogram
+	epoch := rh.epoch()
+	for i := range rh.slots {
+		h := (*atomicHistogram)(atomic.LoadPointer(&rh.slots[i]))
+		if h == nil || h.epoch <= epoch-rh.size || h.epoch > epoch {
+			continue
+		}
+
+		for j := range h.counts {
+			count := atomic.LoadInt64(&h.counts[j])
+			merged.counts[j] += count
+			merged.Count += count
+		}
+		merged.Sum += math.Float64frombits(atomic.LoadUint64(&h.sum))
+		merged.Max = math.Max(merged.Max, math.Float64frombits(atomic.LoadUint64(&h.max)))
+	}
+
+	return merged
+}
+
+// Reset clears all histograms, the adds racing with it might be kept or not.
+func (rh *AtomicRollingHistogram) Reset() {
+	for i := range rh.slots {
+		atomic.StorePointer(&rh.slots[i], nil)
+	}
+}
+
+func (rh *AtomicRollingHistogram) epoch() int64 {
+	return int64(rh.clock.Now() / rh.interval)
+}
+
+// WithAtomicRollingHistogramClock lets the AtomicRollingHistogram tell the time with clock.
+func WithAtomicRollingHistogramClock(clock timex.Clock) AtomicRollingHistogramOption {
+	return func(h *AtomicRollingHistogram) {
+		h.clock = clock
+	}
+}
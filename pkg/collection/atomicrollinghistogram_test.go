@@ -0,0 +1,103 @@
+package collection
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAtomicRollingHistogram(t *testing.T) {
+	assert.NotNil(t, NewAtomicRollingHistogram(10, time.Second))
+	assert.Panics(t, func() {
+		NewAtomicRollingHistogram(0, time.Second)
+	})
+}
+
+func TestAtomicRollingHistogramAdd(t *testing.T) {
+	clock := timex.NewManualClock(time.Hour)
+	r := NewAtomicRollingHistogram(3, duration, WithAtomicRollingHistogramClock(clock))
+	snapshot := r.Snapshot()
+	assert.Equal(t, int64(0), snapshot.Count)
+	r.Add(1)
+	clock.Advance(duration)
+	r.Add(2)
+	r.Add(3)
+	clock.Advance(duration)
+	r.Add(4)
+	r.Add(5)
+	r.Add(6)
+	snapshot = r.Snapshot()
+	assert.Equal(t, int64(6), snapshot.Count)
+	assert.Equal(t, float64(6), snapshot.Max)
+	assert.Equal(t, 3.5, snapshot.Mean())
+	clock.Advance(duration)
+	r.Add(7)
+	snapshot = r.Snapshot()
+	assert.Equal(t, int64(6), snapshot.Count)
+	assert.Equal(t, float64(7), snapshot.Max)
+	assert.Equal(t, 4.5, snapshot.Mean())
+
+	// cross window
+	clock.Advance(duration * 10)
+	snapshot = r.Snapshot()
+	assert.Equal(t, int64(0), snapshot.Count)
+	assert.Equal(t, float64(0), snapshot.Max)
+}
+
+func TestAtomicRollingHistogramQuantile(t *testing.T) {
+	r := NewAtomicRollingHistogram(10, time.Second)
+	for i := 1; i <= 1000; i++ {
+		r.Add(float64(i) / 1000)
+	}
+	snapshot := r.Snapshot()
+	assert.Equal(t, int64(1000), snapshot.Count)
+	assert.InEpsilon(t, 0.5, snapshot.Quantile(0.5), histogramEpsilon)
+	assert.InEpsilon(t, 0.99, snapshot.Quantile(0.99), histogramEpsilon)
+	assert.Equal(t, float64(1), snapshot.Quantile(1))
+
+	r.Reset()
+	snapshot = r.Snapshot()
+	assert.Equal(t, int64(0), snapshot.Count)
+}
+
+func TestAtomicRollingHistogramConcurrent(t *testing.T) {
+	r := NewAtomicRollingHistogram(10, duration, WithAtomicRollingHistogramClock(timex.NewManualClock(0)))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; j <= 1000; j++ {
+				r.Add(float64(j))
+				r.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := r.Snapshot()
+	assert.Equal(t, int64(8000), snapshot.Count)
+	assert.Equal(t, float64(8*500500), snapshot.Sum)
+	assert.Equal(t, float64(1000), snapshot.Max)
+}
+
+func BenchmarkRollingHistogramAdd(b *testing.B) {
+	r := NewRollingHistogram(10, time.Second)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Add(0.01)
+		}
+	})
+}
+
+func BenchmarkAtomicRollingHistogramAdd(b *testing.B) {
+	r := NewAtomicRollingHistogram(10, time.Second)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Add(0.01)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package collection
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+// maxWindowStripes caps the stripes of an AtomicRollingWindow.
+const maxWindowStripes = 16
+
+type (
+	// AtomicRollingWindowOption let callers customize the AtomicRollingWindow.
+	AtomicRollingWindowOption func(rollingWindow *AtomicRollingWindow)
+
+	// AtomicRollingWindow is a RollingWindow of integer values that doesn't take locks,
+	// it's meant for the hot paths that are called from many goroutines.
+	// The buckets are aligned to the multiples of interval since the clock's zero time.
+	// The buckets are sharded in stripes by P, so that the writers don't contend, and summed on read.
+	// A bucket is replaced rather than cleared when it expires, so that no add is lost to the expiry.
+	AtomicRollingWindow struct {
+		size    int64
+		stripes *stripes
+		// slots hold the *atomicBucket of each stripe and bucket, stripe by stripe, nil if not used.
+		slots    []unsafe.Pointer
+		interval time.Duration
+		clock    timex.Clock
+		// settled caches the *settledSum of the buckets that take no more adds, see Sum.
+		settled unsafe.Pointer
+		// generation is increased by Reset, which invalidates settled.
+		generation int64
+	}
+
+	atomicBucket struct {
+		// epoch is the index of the interval the bucket counts for, which never changes.
+		epoch int64
+		sum   int64
+		count int64
+		// pad the bucket to a cache line, so that adding to a bucket doesn't invalidate its neighbours.
+		_ [40]byte
+	}
+
+	// settledSum is the sum of the buckets older than the previous one at epoch.
+	settledSum struct {
+		epoch      int64
+		generation int64
+		sum        int64
+		count      int64
+	}
+)
+
+// NewAtomicRollingWindow returns an AtomicRollingWindow that with size buckets and time interval,
+// use opts to customize the AtomicRollingWindow.
+func NewAtomicRollingWindow(size int, interval time.Duration, opts ...AtomicRollingWindowOption) *AtomicRollingWindow {
+	if size < 1 {
+		panic("size must be greater than 0")
+	}
+
+	stripes := newStripes(maxWindowStripes)
+	w := &AtomicRollingWindow{
+		size:     int64(size),
+		stripes:  stripes,
+		slots:    make([]unsafe.Pointer, size*stripes.n),
+		interval: interval,
+		clock:    timex.RealClock(),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Add adds value to current bucket.
+func (rw *AtomicRollingWindow) Add(v int64) {
+	epoch := rw.epoch()
+	stripe := rw.stripes.get()
+	b := rw.bucket(&rw.slots[int64(*stripe)*rw.size+epoch%rw.size], epoch)
+	rw.stripes.put(stripe)
+
+	atomic.AddInt64(&b.sum, v)
+	atomic.AddInt64(&b.count, 1)
+}
+
+// bucket returns the bucket of epoch in slot, which replaces the expired one.
+func (rw *AtomicRollingWindow) bucket(slot *unsafe.Pointer, epoch int64) *atomicBucket {
+	for {
+		p := atomic.LoadPointer(slot)
+		// a newer bucket means the clock moved on meanwhile, the value counts for the newer interval.
+		if b := (*atomicBucket)(p); b != nil && b.epoch >= epoch {
+			return b
+		}
+
+		b := &atomicBucket{epoch: epoch}
+		if atomic.CompareAndSwapPointer(slot, p, unsafe.Pointer(b)) {
+			return b
+		}
+	}
+}
+
+// Reduce runs fn on all buckets that are not expired, from the oldest to the current one.
+func (rw *AtomicRollingWindow) Reduce(fn func(b *Bucket)) {
+	var bucket Bucket
+	epoch := rw.epoch()
+	for e := epoch - rw.size + 1; e <= epoch; e++ {
+		if e < 0 {
+			continue
+		}
+
+		sum, count := rw.sumEpoch(e)
+		bucket.Sum = float64(sum)
+		bucket.Count = count
+		fn(&bucket)
+	}
+}
+
+// Sum returns the sum and the number of values in all buckets that are not expired.
+// The buckets older than the previous one take no more adds, their sum is cached until the next interval,
+// so that the reads cost the stripes of two buckets rather than of all.
+func (rw *AtomicRollingWindow) Sum() (sum, count int64) {
+	epoch := rw.epoch()
+	settled := (*settledSum)(atomic.LoadPointer(&rw.settled))
+	if settled == nil || settled.epoch != epoch || settled.generation != atomic.LoadInt64(&rw.generation) {
+		settled = rw.settle(epoch)
+	}
+
+	sum, count = settled.sum, settled.count
+	// the previous bucket may still take the adds that raced with the turn of the interval.
+	for e := epoch - 1; e <= epoch; e++ {
+		if e < 0 || e <= epoch-rw.size {
+			continue
+		}
+
+		s, c := rw.sumEpoch(e)
+		sum += s
+		count += c
+	}
+
+	return
+}
+
+// settle sums the buckets older than the previous one at epoch, and caches the sum.
+func (rw *AtomicRollingWindow) settle(epoch int64) *settledSum {
+	settled := &settledSum{
+		epoch:      epoch,
+		generation: atomic.LoadInt64(&rw.generation),
+	}
+	for e := epoch - rw.size + 1; e < epoch-1; e++ {
+		if e < 0 {
+			continue
+		}
+
+		s, c := rw.sumEpoch(e)
+		settled.sum += s
+		settled.count += c
+	}
+	atomic.StorePointer(&rw.settled, unsafe.Pointer(settled))
+
+	return settled
+}
+
+// sumEpoch returns the sum and the number of values of the interval epoch in all stripes.
+func (rw *AtomicRollingWindow) sumEpoch(epoch int64) (sum, count int64) {
+	for i := epoch % rw.size; i < int64(len(rw.slots)); i += rw.size {
+		if b := (*atomicBucket)(atomic.LoadPointer(&rw.slots[i])); b != nil && b.epoch == epoch {
+			sum += atomic.LoadInt64(&b.sum)
+			count += atomic.LoadInt64(&b.count)
+		}
+	}
+
+	return
+}
+
+// Reset clears all buckets, the adds racing with it might be kept or not.
+func (rw *AtomicRollingWindow) Reset() {
+	for i := range rw.slots {
+		atomic.StorePointer(&rw.slots[i], nil)
+	}
+	atomic.StorePointer(&rw.settled, nil)
+	// a sum settled meanwhile might count the cleared buckets, which the new generation invalidates.
+	atomic.AddInt64(&rw.generation, 1)
+}
+
+func (rw *AtomicRollingWindow) epoch() int64 {
+	return int64(rw.clock.Now() / rw.interval)
+}
+
+// WithAtomicRollingWindowClock lets the AtomicRollingWindow tell the time with clock.
+func WithAtomicRollingWindowClock(clock timex.Clock) AtomicRollingWindowOption {
+	return func(w *AtomicRollingWindow) {
+		w.clock = clock
+	}
+}
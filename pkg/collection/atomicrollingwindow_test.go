@@ -0,0 +1,129 @@
+package collection
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAtomicRollingWindow(t *testing.T) {
+	assert.NotNil(t, NewAtomicRollingWindow(10, time.Second))
+	assert.Panics(t, func() {
+		NewAtomicRollingWindow(0, time.Second)
+	})
+}
+
+func TestAtomicRollingWindowAdd(t *testing.T) {
+	const size = 3
+	clock := timex.NewManualClock(time.Hour)
+	r := NewAtomicRollingWindow(size, duration, WithAtomicRollingWindowClock(clock))
+	listBuckets := func() []float64 {
+		var buckets []float64
+		r.Reduce(func(b *Bucket) {
+			buckets = append(buckets, b.Sum)
+		})
+		return buckets
+	}
+	assert.Equal(t, []float64{0, 0, 0}, listBuckets())
+	r.Add(1)
+	assert.Equal(t, []float64{0, 0, 1}, listBuckets())
+	clock.Advance(duration)
+	r.Add(2)
+	r.Add(3)
+	assert.Equal(t, []float64{0, 1, 5}, listBuckets())
+	clock.Advance(duration)
+	r.Add(4)
+	r.Add(5)
+	r.Add(6)
+	assert.Equal(t, []float64{1, 5, 15}, listBuckets())
+	sum, count := r.Sum()
+	assert.Equal(t, int64(21), sum)
+	assert.Equal(t, int64(6), count)
+	clock.Advance(duration)
+	r.Add(7)
+	assert.Equal(t, []float64{5, 15, 7}, listBuckets())
+
+	// cross window
+	clock.Advance(duration * 10)
+	assert.Equal(t, []float64{0, 0, 0}, listBuckets())
+	r.Add(8)
+	assert.Equal(t, []float64{0, 0, 8}, listBuckets())
+}
+
+func TestAtomicRollingWindowStart(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	r := NewAtomicRollingWindow(3, duration, WithAtomicRollingWindowClock(clock))
+	r.Add(1)
+	var buckets []float64
+	r.Reduce(func(b *Bucket) {
+		buckets = append(buckets, b.Sum)
+	})
+	assert.Equal(t, []float64{1}, buckets)
+}
+
+func TestAtomicRollingWindowReset(t *testing.T) {
+	clock := timex.NewManualClock(time.Hour)
+	r := NewAtomicRollingWindow(3, duration, WithAtomicRollingWindowClock(clock))
+	r.Add(1)
+	clock.Advance(duration)
+	r.Add(2)
+	r.Reset()
+	sum, count := r.Sum()
+	assert.Equal(t, int64(0), sum)
+	assert.Equal(t, int64(0), count)
+	r.Add(3)
+	sum, count = r.Sum()
+	assert.Equal(t, int64(3), sum)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAtomicRollingWindowConcurrent(t *testing.T) {
+	r := NewAtomicRollingWindow(10, duration, WithAtomicRollingWindowClock(timex.NewManualClock(0)))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.Add(1)
+				r.Sum()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sum, count := r.Sum()
+	assert.Equal(t, int64(8000), sum)
+	assert.Equal(t, int64(8000), count)
+}
+
+func BenchmarkRollingWindowAdd(b *testing.B) {
+	r := NewRollingWindow(40, time.Second/4)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Add(1)
+		}
+	})
+}
+
+func BenchmarkAtomicRollingWindowAdd(b *testing.B) {
+	r := NewAtomicRollingWindow(40, time.Second/4)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Add(1)
+		}
+	})
+}
+
+func BenchmarkAtomicRollingWindowAddSum(b *testing.B) {
+	r := NewAtomicRollingWindow(40, time.Second/4)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Add(1)
+			r.Sum()
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package collection
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+type (
+	// DecayCounterOption let callers customize the DecayCounter.
+	DecayCounterOption func(counter *DecayCounter)
+
+	// DecayCounter counts the sum and the number of added values like a Bucket,
+	// but both of them decay exponentially with time instead of expiring in steps,
+	// a value added halfLife ago only weighs half.
+	DecayCounter struct {
+		lock     sync.Mutex
+		halfLife time.Duration
+		sum      float64
+		count    float64
+		lastTime time.Duration
+		clock    timex.Clock
+	}
+)
+
+// NewDecayCounter returns a DecayCounter with the given half-life,
+// use opts to customize the DecayCounter.
+func NewDecayCounter(halfLife time.Duration, opts ...DecayCounterOption) *DecayCounter {
+	if halfLife <= 0 {
+		panic("halfLife must be greater than 0")
+	}
+
+	dc := &DecayCounter{
+		halfLife: halfLife,
+		clock:    timex.RealClock(),
+	}
+	for _, opt := range opts {
+		opt(dc)
+	}
+	dc.lastTime = dc.clock.Now()
+	return dc
+}
+
+// Add adds v to the sum and increases the count by one.
+func (dc *DecayCounter) Add(v float64) {
+	dc.lock.Lock()
+	dc.decay()
+	dc.sum += v
+	dc.count++
+	dc.lock.Unlock()
+}
+
+// Value returns the decayed sum and count.
+func (dc *DecayCounter) Value() (sum, count float64) {
+	dc.lock.Lock()
+	dc.decay()
+	sum, count = dc.sum, dc.count
+	dc.lock.Unlock()
+
+	return
+}
+
+// Reset clears the sum and the count.
+func (dc *DecayCounter) Reset() {
+	dc.lock.Lock()
+	dc.sum = 0
+	dc.count = 0
+	dc.lastTime = dc.clock.Now()
+	dc.lock.Unlock()
+}
+
+func (dc *DecayCounter) decay() {
+	now := dc.clock.Now()
+	elapsed := now - dc.lastTime
+	if elapsed <= 0 {
+		return
+	}
+
+	factor := math.Exp2(-float64(elapsed) / float64(dc.halfLife))
+	dc.sum *= factor
+	dc.count *= factor
+	dc.lastTime = now
+}
+
+// WithDecayCounterClock lets the DecayCounter tell the time with clock.
+func WithDecayCounterClock(clock timex.Clock) DecayCounterOption {
+	return func(dc *DecayCounter) {
+		dc.clock = clock
+	}
+}
@@ -0,0 +1,63 @@
+package collection
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDecayCounter(t *testing.T) {
+	assert.NotNil(t, NewDecayCounter(time.Second))
+	assert.Panics(t, func() {
+		NewDecayCounter(0)
+	})
+}
+
+func TestDecayCounter(t *testing.T) {
+	clock := timex.NewManualClock(0)
+	dc := NewDecayCounter(time.Second, WithDecayCounterClock(clock))
+
+	for i := 0; i < 10; i++ {
+		dc.Add(1)
+	}
+	dc.Add(0)
+	sum, count := dc.Value()
+	assert.Equal(t, float64(10), sum)
+	assert.Equal(t, float64(11), count)
+
+	clock.Advance(time.Second)
+	sum, count = dc.Value()
+	assert.InDelta(t, 5, sum, 1e-9)
+	assert.InDelta(t, 5.5, count, 1e-9)
+
+	clock.Advance(time.Second)
+	dc.Add(1)
+	sum, count = dc.Value()
+	assert.InDelta(t, 3.5, sum, 1e-9)
+	assert.InDelta(t, 3.75, count, 1e-9)
+
+	// decays smoothly rather than in steps
+	clock.Advance(time.Millisecond * 100)
+	sum1, _ := dc.Value()
+	clock.Advance(time.Millisecond * 100)
+	sum2, _ := dc.Value()
+	assert.True(t, sum > sum1 && sum1 > sum2)
+	assert.InDelta(t, sum*math.Exp2(-0.2), sum2, 1e-9)
+
+	dc.Reset()
+	sum, count = dc.Value()
+	assert.Equal(t, float64(0), sum)
+	assert.Equal(t, float64(0), count)
+}
+
+func TestDecayCounterRealTime(t *testing.T) {
+	dc := NewDecayCounter(duration)
+	dc.Add(1)
+	time.Sleep(duration)
+	sum, count := dc.Value()
+	assert.True(t, sum < 0.6)
+	assert.True(t, count < 0.6)
+}
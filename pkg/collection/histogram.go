@@ -0,0 +1,130 @@
+package collection
+
+import "math"
+
+const (
+	// histogramMin is the upper bound of the first bucket, values below it are counted in the first bucket.
+	histogramMin = 1e-6
+	// histogramBucketsPerOctave buckets between each power of two, the relative error is below 9%.
+	histogramBucketsPerOctave = 4
+	// 40 octaves cover 1e-6 to 1e6.
+	histogramBuckets = 40*histogramBucketsPerOctave + 1
+)
+
+// Histogram is a histogram with fixed log-scaled buckets,
+// it's designed for positive values between 1e-6 and 1e6, e.g. latencies in seconds.
+// Histogram is not thread safe.
+type Histogram struct {
+	Count  int64
+	Sum    float64
+	Max    float64
+	counts [histogramBuckets]int64
+}
+
+// Add adds v into the histogram, NaN and infinities are dropped.
+func (h *Histogram) Add(v float64) {
+	if !finite(v) {
+		return
+	}
+
+	h.counts[histogramIndex(v)]++
+	h.Count++
+	h.Sum += v
+	if v > h.Max {
+		h.Max = v
+	}
+}
+
+// Merge adds all values of o into the histogram.
+func (h *Histogram) Merge(o *Histogram) {
+	for i, count := range o.counts {
+		h.counts[i] += count
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+}
+
+// Mean returns the mean of the values, 0 if empty.
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	return h.Sum / float64(h.Count)
+}
+
+// Quantile returns the approximate value at quantile q, which is between 0 and 1, 0 if empty.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(h.Count)))
+	if rank >= h.Count {
+		return h.Max
+	}
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, count := range h.counts[:histogramBuckets-1] {
+		seen += count
+		if seen >= rank {
+			return math.Min(histogramValue(i), h.Max)
+		}
+	}
+
+	// the rank falls into the overflow bucket
+	return h.Max
+}
+
+// CountBelow returns the approximate number of values not greater than v.
+func (h *Histogram) CountBelow(v float64) int64 {
+	if v >= h.Max {
+		return h.Count
+	}
+
+	var count int64
+	last := histogramIndex(v)
+	for i := 0; i <= last; i++ {
+		count += h.counts[i]
+	}
+
+	return count
+}
+
+// Reset clears the histogram.
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
+
+func histogramIndex(v float64) int {
+	// NaN would turn into an arbitrary index.
+	if v <= histogramMin || math.IsNaN(v) {
+		return 0
+	}
+
+	i := 1 + math.Log2(v/histogramMin)*histogramBucketsPerOctave
+	if i >= histogramBuckets-1 {
+		return histogramBuckets - 1
+	}
+
+	return int(i)
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// histogramValue returns the geometric mean of the bounds of bucket i.
+func histogramValue(i int) float64 {
+	if i == 0 {
+		return histogramMin
+	}
+
+	return histogramMin * math.Exp2((float64(i)-0.5)/histogramBucketsPerOctave)
+}
@@ -0,0 +1,106 @@
+package collection
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const histogramEpsilon = 0.1
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	assert.Equal(t, float64(0), h.Mean())
+	assert.Equal(t, float64(0), h.Quantile(0.5))
+
+	for i := 1; i <= 1000; i++ {
+		h.Add(float64(i) / 1000)
+	}
+
+	assert.Equal(t, int64(1000), h.Count)
+	assert.Equal(t, float64(1), h.Max)
+	assert.InEpsilon(t, 0.5005, h.Mean(), 1e-9)
+	assert.InEpsilon(t, 0.5, h.Quantile(0.5), histogramEpsilon)
+	assert.InEpsilon(t, 0.95, h.Quantile(0.95), histogramEpsilon)
+	assert.InEpsilon(t, 0.99, h.Quantile(0.99), histogramEpsilon)
+	assert.Equal(t, float64(1), h.Quantile(1))
+	assert.InEpsilon(t, 0.001, h.Quantile(0), histogramEpsilon)
+	assert.Equal(t, int64(1000), h.CountBelow(1))
+	assert.InEpsilon(t, 500, float64(h.CountBelow(0.5)), histogramEpsilon)
+	assert.Equal(t, int64(0), h.CountBelow(0))
+
+	h.Reset()
+	assert.Equal(t, int64(0), h.Count)
+	assert.Equal(t, float64(0), h.Quantile(0.5))
+}
+
+func TestHistogramRange(t *testing.T) {
+	var h Histogram
+	h.Add(0)
+	h.Add(-1)
+	assert.Equal(t, float64(0), h.Quantile(0.5))
+	assert.Equal(t, 0, histogramIndex(histogramMin/2))
+
+	h.Reset()
+	h.Add(1)
+	h.Add(1e9)
+	assert.Equal(t, float64(1e9), h.Quantile(0.99))
+	assert.Equal(t, float64(1e9), h.Quantile(1))
+	assert.Equal(t, histogramBuckets-1, histogramIndex(math.Inf(1)))
+}
+
+func TestHistogramNotFinite(t *testing.T) {
+	var h Histogram
+	h.Add(1)
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		assert.NotPanics(t, func() {
+			h.Add(v)
+		})
+	}
+	assert.Equal(t, int64(1), h.Count)
+	assert.Equal(t, float64(1), h.Sum)
+	assert.Equal(t, float64(1), h.Max)
+	assert.Equal(t, 0, histogramIndex(math.NaN()))
+	assert.Equal(t, int64(0), h.CountBelow(math.NaN()))
+
+	r := NewAtomicRollingHistogram(10, time.Second)
+	r.Add(1)
+	r.Add(math.NaN())
+	r.Add(math.Inf(1))
+	snapshot := r.Snapshot()
+	assert.Equal(t, int64(1), snapshot.Count)
+	assert.Equal(t, float64(1), snapshot.Sum)
+	assert.Equal(t, float64(1), snapshot.Max)
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b, all Histogram
+	for i := 0; i < 1000; i++ {
+		v := rand.ExpFloat64() / 100
+		all.Add(v)
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+
+	a.Merge(&b)
+	assert.Equal(t, all.Count, a.Count)
+	assert.Equal(t, all.Max, a.Max)
+	assert.InEpsilon(t, all.Sum, a.Sum, 1e-9)
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		assert.Equal(t, all.Quantile(q), a.Quantile(q))
+	}
+}
+
+func BenchmarkHistogramAdd(b *testing.B) {
+	var h Histogram
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Add(float64(i%1000) / 1000)
+	}
+}
@@ -0,0 +1,187 @@
+package collection
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+)
+
+type (
+	// RollingHistogramOption let callers customize the RollingHistogram.
+	RollingHistogramOption func(rollingHistogram *RollingHistogram)
+
+	// RollingHistogram defines a rolling window of histograms with time interval,
+	// the histograms expire like the buckets of RollingWindow.
+	RollingHistogram struct {
+		lock          sync.RWMutex
+		size          int
+		slots         []*Histogram
+		interval      time.Duration
+		offset        int
+		ignoreCurrent bool
+		lastTime      time.Duration // start time of the last slot
+		clock         timex.Clock
+	}
+)
+
+// NewRollingHistogram returns a RollingHistogram that with size histograms and time interval,
+// use opts to customize the RollingHistogram.
+func NewRollingHistogram(size int, interval time.Duration, opts ...RollingHistogramOption) *RollingHistogram {
+	if size < 1 {
+		panic("size must be greater than 0")
+	}
+
+	slots := make([]*Histogram, size)
+	for i := range slots {
+		slots[i] = new(Histogram)
+	}
+
+	h := &RollingHistogram{
+		size:     size,
+		slots:    slots,
+		interval: interval,
+		clock:    timex.RealClock(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.lastTime = h.clock.Now()
+	return h
+}
+
+// Add adds value to current histogram, NaN and infinities are dropped.
+func (rh *RollingHistogram) Add(v float64) {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	rh.updateOffset()
+	rh.slots[rh.offset].Add(v)
+}
+
+// Reduce runs fn on all histograms, ignore current histogram if ignoreCurrent was set.
+func (rh *RollingHistogram) Reduce(fn func(h *Histogram)) {
+	rh.lock.RLock()
+	defer rh.lock.RUnlock()
+
+	var diff int
+	span := rh.span()
+	// ignore current histogram, because of partial data
+	if span == 0 && rh.ignoreCurrent {
+		diff = rh.size - 1
+	} else {
+		diff = rh.size - span
+	}
+	if diff > 0 {
+		offset := (rh.offset + span + 1) % rh.size
+		for i := 0; i < diff; i++ {
+			fn(rh.slots[(offset+i)%rh.size])
+		}
+	}
+}
+
+// Snapshot returns a Histogram that merges all histograms in the window.
+func (rh *RollingHistogram) Snapshot() Histogram {
+	var merged Histogram
+	rh.Reduce(func(h *Histogram) {
+		merged.Merge(h)
+	})
+
+	return merged
+}
+
+// Quantile returns the approximate value at quantile q of all values in the window.
+func (rh *RollingHistogram) Quantile(q float64) float64 {
+	merged := rh.Snapshot()
+	return merged.Quantile(q)
+}
+
+// Mean returns the mean of all values in the window.
+func (rh *RollingHistogram) Mean() float64 {
+	var sum float64
+	var count int64
+	rh.Reduce(func(h *Histogram) {
+		sum += h.Sum
+		count += h.Count
+	})
+
+	if count == 0 {
+		return 0
+	}
+
+	return sum / float64(count)
+}
+
+// Max returns the max value in the window.
+func (rh *RollingHistogram) Max() float64 {
+	var max float64
+	rh.Reduce(func(h *Histogram) {
+		if h.Max > max {
+			max = h.Max
+		}
+	})
+
+	return max
+}
+
+// Count returns the number of values in the window.
+func (rh *RollingHistogram) Count() int64 {
+	var count int64
+	rh.Reduce(func(h *Histogram) {
+		count += h.Count
+	})
+
+	return count
+}
+
+// Reset clears all histograms.
+func (rh *RollingHistogram) Reset() {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+
+	for _, h := range rh.slots {
+		h.Reset()
+	}
+	rh.offset = 0
+	rh.lastTime = rh.clock.Now()
+}
+
+func (rh *RollingHistogram) span() int {
+	offset := int(rh.clock.Since(rh.lastTime) / rh.interval)
+	if 0 <= offset && offset < rh.size {
+		return offset
+	}
+
+	return rh.size
+}
+
+func (rh *RollingHistogram) updateOffset() {
+	span := rh.span()
+	if span <= 0 {
+		return
+	}
+
+	offset := rh.offset
+	// reset expired histograms
+	for i := 0; i < span; i++ {
+		rh.slots[(offset+i+1)%rh.size].Reset()
+	}
+
+	rh.offset = (offset + span) % rh.size
+	now := rh.clock.Now()
+	// align to interval time boundary
+	rh.lastTime = now - (now-rh.lastTime)%rh.interval
+}
+
+// IgnoreCurrentHistogram lets the Reduce call ignore current histogram.
+func IgnoreCurrentHistogram() RollingHistogramOption {
+	return func(h *RollingHistogram) {
+		h.ignoreCurrent = true
+	}
+}
+
+// WithRollingHistogramClock lets the RollingHistogram tell the time with clock.
+func WithRollingHistogramClock(clock timex.Clock) RollingHistogramOption {
+	return func(h *RollingHistogram) {
+		h.clock = clock
+	}
+}
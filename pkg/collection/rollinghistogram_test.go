@@ -0,0 +1,112 @@
+package collection
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/chenquan/sqlbreaker/pkg/timex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRollingHistogram(t *testing.T) {
+	assert.NotNil(t, NewRollingHistogram(10, time.Second))
+	assert.Panics(t, func() {
+		NewRollingHistogram(0, time.Second)
+	})
+}
+
+func TestRollingHistogramAdd(t *testing.T) {
+	const size = 3
+	clock := timex.NewManualClock(0)
+	r := NewRollingHistogram(size, duration, WithRollingHistogramClock(clock))
+	listCounts := func() []int64 {
+		var counts []int64
+		r.Reduce(func(h *Histogram) {
+			counts = append(counts, h.Count)
+		})
+		return counts
+	}
+	assert.Equal(t, []int64{0, 0, 0}, listCounts())
+	r.Add(1)
+	assert.Equal(t, []int64{0, 0, 1}, listCounts())
+	clock.Advance(duration)
+	r.Add(2)
+	r.Add(3)
+	assert.Equal(t, []int64{0, 1, 2}, listCounts())
+	clock.Advance(duration)
+	r.Add(4)
+	r.Add(5)
+	r.Add(6)
+	assert.Equal(t, []int64{1, 2, 3}, listCounts())
+	assert.Equal(t, int64(6), r.Count())
+	assert.Equal(t, float64(6), r.Max())
+	assert.Equal(t, 3.5, r.Mean())
+	clock.Advance(duration)
+	r.Add(7)
+	assert.Equal(t, []int64{2, 3, 1}, listCounts())
+	assert.Equal(t, float64(7), r.Max())
+	assert.Equal(t, 4.5, r.Mean())
+}
+
+func TestRollingHistogramIgnoreCurrent(t *testing.T) {
+	const size = 3
+	clock := timex.NewManualClock(0)
+	r := NewRollingHistogram(size, duration, IgnoreCurrentHistogram(), WithRollingHistogramClock(clock))
+	r.Add(1)
+	assert.Equal(t, int64(0), r.Count())
+	clock.Advance(duration)
+	assert.Equal(t, int64(1), r.Count())
+	clock.Advance(duration)
+	clock.Advance(duration)
+	assert.Equal(t, int64(0), r.Count())
+}
+
+func TestRollingHistogramQuantile(t *testing.T) {
+	r := NewRollingHistogram(10, time.Second)
+	assert.Equal(t, float64(0), r.Quantile(0.5))
+	assert.Equal(t, float64(0), r.Mean())
+
+	for i := 1; i <= 1000; i++ {
+		r.Add(float64(i) / 1000)
+	}
+	assert.InEpsilon(t, 0.5, r.Quantile(0.5), histogramEpsilon)
+	assert.InEpsilon(t, 0.99, r.Quantile(0.99), histogramEpsilon)
+	assert.Equal(t, float64(1), r.Quantile(1))
+
+	snapshot := r.Snapshot()
+	assert.Equal(t, int64(1000), snapshot.Count)
+
+	r.Reset()
+	assert.Equal(t, int64(0), r.Count())
+	assert.Equal(t, float64(0), r.Max())
+}
+
+func TestRollingHistogramDataRace(t *testing.T) {
+	const size = 3
+	r := NewRollingHistogram(size, duration)
+	stop := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.Add(rand.Float64())
+				time.Sleep(duration / 2)
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.Quantile(0.99)
+			}
+		}
+	}()
+	time.Sleep(duration * 5)
+	close(stop)
+}
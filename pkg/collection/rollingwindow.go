@@ -20,6 +20,7 @@ type (
 		offset        int
 		ignoreCurrent bool
 		lastTime      time.Duration // start time of the last bucket
+		clock         timex.Clock
 	}
 )
 
@@ -34,11 +35,12 @@ func NewRollingWindow(size int, interval time.Duration, opts ...RollingWindowOpt
 		size:     size,
 		win:      newWindow(size),
 		interval: interval,
-		lastTime: timex.Now(),
+		clock:    timex.RealClock(),
 	}
 	for _, opt := range opts {
 		opt(w)
 	}
+	w.lastTime = w.clock.Now()
 	return w
 }
 
@@ -69,8 +71,20 @@ func (rw *RollingWindow) Reduce(fn func(b *Bucket)) {
 	}
 }
 
+// Reset clears all buckets.
+func (rw *RollingWindow) Reset() {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+
+	for i := 0; i < rw.size; i++ {
+		rw.win.resetBucket(i)
+	}
+	rw.offset = 0
+	rw.lastTime = rw.clock.Now()
+}
+
 func (rw *RollingWindow) span() int {
-	offset := int(timex.Since(rw.lastTime) / rw.interval)
+	offset := int(rw.clock.Since(rw.lastTime) / rw.interval)
 	if 0 <= offset && offset < rw.size {
 		return offset
 	}
@@ -91,7 +105,7 @@ func (rw *RollingWindow) updateOffset() {
 	}
 
 	rw.offset = (offset + span) % rw.size
-	now := timex.Now()
+	now := rw.clock.Now()
 	// align to interval time boundary
 	rw.lastTime = now - (now-rw.lastTime)%rw.interval
 }
@@ -148,3 +162,10 @@ func IgnoreCurrentBucket() RollingWindowOption {
 		w.ignoreCurrent = true
 	}
 }
+
+// WithRollingWindowClock lets the RollingWindow tell the time with clock.
+func WithRollingWindowClock(clock timex.Clock) RollingWindowOption {
+	return func(w *RollingWindow) {
+		w.clock = clock
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/chenquan/sqlbreaker/pkg/timex"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,7 +21,8 @@ func TestNewRollingWindow(t *testing.T) {
 
 func TestRollingWindowAdd(t *testing.T) {
 	const size = 3
-	r := NewRollingWindow(size, duration)
+	clock := timex.NewManualClock(0)
+	r := NewRollingWindow(size, duration, WithRollingWindowClock(clock))
 	listBuckets := func() []float64 {
 		var buckets []float64
 		r.Reduce(func(b *Bucket) {
@@ -31,23 +33,24 @@ func TestRollingWindowAdd(t *testing.T) {
 	assert.Equal(t, []float64{0, 0, 0}, listBuckets())
 	r.Add(1)
 	assert.Equal(t, []float64{0, 0, 1}, listBuckets())
-	elapse()
+	clock.Advance(duration)
 	r.Add(2)
 	r.Add(3)
 	assert.Equal(t, []float64{0, 1, 5}, listBuckets())
-	elapse()
+	clock.Advance(duration)
 	r.Add(4)
 	r.Add(5)
 	r.Add(6)
 	assert.Equal(t, []float64{1, 5, 15}, listBuckets())
-	elapse()
+	clock.Advance(duration)
 	r.Add(7)
 	assert.Equal(t, []float64{5, 15, 7}, listBuckets())
 }
 
 func TestRollingWindowReset(t *testing.T) {
 	const size = 3
-	r := NewRollingWindow(size, duration, IgnoreCurrentBucket())
+	clock := timex.NewManualClock(0)
+	r := NewRollingWindow(size, duration, IgnoreCurrentBucket(), WithRollingWindowClock(clock))
 	listBuckets := func() []float64 {
 		var buckets []float64
 		r.Reduce(func(b *Bucket) {
@@ -56,44 +59,65 @@ func TestRollingWindowReset(t *testing.T) {
 		return buckets
 	}
 	r.Add(1)
-	elapse()
+	clock.Advance(duration)
 	assert.Equal(t, []float64{0, 1}, listBuckets())
-	elapse()
+	clock.Advance(duration)
 	assert.Equal(t, []float64{1}, listBuckets())
-	elapse()
+	clock.Advance(duration)
 	assert.Nil(t, listBuckets())
 
 	// cross window
 	r.Add(1)
-	time.Sleep(duration * 10)
+	clock.Advance(duration * 10)
 	assert.Nil(t, listBuckets())
 }
 
+func TestRollingWindowClear(t *testing.T) {
+	const size = 3
+	clock := timex.NewManualClock(0)
+	r := NewRollingWindow(size, duration, WithRollingWindowClock(clock))
+	listBuckets := func() []float64 {
+		var buckets []float64
+		r.Reduce(func(b *Bucket) {
+			buckets = append(buckets, b.Sum)
+		})
+		return buckets
+	}
+	r.Add(1)
+	clock.Advance(duration)
+	r.Add(2)
+	assert.Equal(t, []float64{0, 1, 2}, listBuckets())
+	r.Reset()
+	assert.Equal(t, []float64{0, 0, 0}, listBuckets())
+	r.Add(3)
+	assert.Equal(t, []float64{0, 0, 3}, listBuckets())
+}
+
 func TestRollingWindowReduce(t *testing.T) {
 	const size = 4
 	tests := []struct {
-		win    *RollingWindow
+		opts   []RollingWindowOption
 		expect float64
 	}{
 		{
-			win:    NewRollingWindow(size, duration),
 			expect: 10,
 		},
 		{
-			win:    NewRollingWindow(size, duration, IgnoreCurrentBucket()),
+			opts:   []RollingWindowOption{IgnoreCurrentBucket()},
 			expect: 4,
 		},
 	}
 
 	for i, test := range tests {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			r := test.win
+			clock := timex.NewManualClock(0)
+			r := NewRollingWindow(size, duration, append(test.opts, WithRollingWindowClock(clock))...)
 			for x := 0; x < size; x++ {
 				for i := 0; i <= x; i++ {
 					r.Add(float64(i))
 				}
 				if x < size-1 {
-					elapse()
+					clock.Advance(duration)
 				}
 			}
 			var result float64
@@ -108,7 +132,8 @@ func TestRollingWindowReduce(t *testing.T) {
 func TestRollingWindowBucketTimeBoundary(t *testing.T) {
 	const size = 3
 	interval := time.Millisecond * 30
-	r := NewRollingWindow(size, interval)
+	clock := timex.NewManualClock(0)
+	r := NewRollingWindow(size, interval, WithRollingWindowClock(clock))
 	listBuckets := func() []float64 {
 		var buckets []float64
 		r.Reduce(func(b *Bucket) {
@@ -119,17 +144,17 @@ func TestRollingWindowBucketTimeBoundary(t *testing.T) {
 	assert.Equal(t, []float64{0, 0, 0}, listBuckets())
 	r.Add(1)
 	assert.Equal(t, []float64{0, 0, 1}, listBuckets())
-	time.Sleep(time.Millisecond * 45)
+	clock.Advance(time.Millisecond * 45)
 	r.Add(2)
 	r.Add(3)
 	assert.Equal(t, []float64{0, 1, 5}, listBuckets())
 	// sleep time should be less than interval, and make the bucket change happen
-	time.Sleep(time.Millisecond * 20)
+	clock.Advance(time.Millisecond * 20)
 	r.Add(4)
 	r.Add(5)
 	r.Add(6)
 	assert.Equal(t, []float64{1, 5, 15}, listBuckets())
-	time.Sleep(time.Millisecond * 100)
+	clock.Advance(time.Millisecond * 100)
 	r.Add(7)
 	r.Add(8)
 	r.Add(9)
@@ -164,7 +189,3 @@ func TestRollingWindowDataRace(t *testing.T) {
 	time.Sleep(duration * 5)
 	close(stop)
 }
-
-func elapse() {
-	time.Sleep(duration)
-}
@@ -0,0 +1,50 @@
+package collection
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// stripes hands out the stripes of a sharded counter, so that the writers on different Ps write to different stripes.
+// A stripe is cached in a sync.Pool, which keeps a private item per P, thus a goroutine mostly gets the stripe of its P
+// and the writers don't contend on the same cache lines.
+type stripes struct {
+	n    int
+	next uint32
+	pool sync.Pool
+}
+
+// newStripes returns stripes as many as the Ps rounded up to a power of two, but no more than max,
+// as the reads go through all of them.
+func newStripes(max int) *stripes {
+	n := 1
+	for procs := runtime.GOMAXPROCS(0); n < procs && n < max; n <<= 1 {
+	}
+
+	s := &stripes{n: n}
+	s.pool.New = func() interface{} {
+		stripe := int(atomic.AddUint32(&s.next, 1)-1) % s.n
+		return &stripe
+	}
+
+	return s
+}
+
+// get returns the stripe of the calling goroutine, which must be given back by put.
+func (s *stripes) get() *int {
+	if s.n == 1 {
+		return &zeroStripe
+	}
+
+	return s.pool.Get().(*int)
+}
+
+func (s *stripes) put(stripe *int) {
+	if s.n > 1 {
+		s.pool.Put(stripe)
+	}
+}
+
+// zeroStripe is the only stripe if there is a single P, which skips the pool.
+var zeroStripe int
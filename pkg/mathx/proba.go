@@ -1,29 +1,100 @@
 package mathx
 
 import (
+	"math"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// A Proba is used to test if true on given probability.
-type Proba struct {
-	// rand.New(...) returns a non thread safe object
-	r    *rand.Rand
-	lock sync.Mutex
-}
+var (
+	_ Decider = (*Proba)(nil)
+	_ Decider = (*Diffusion)(nil)
+)
+
+type (
+	// A Decider decides if true on given probability.
+	Decider interface {
+		TrueOnProba(proba float64) bool
+	}
+
+	// A Proba is used to test if true on given probability.
+	Proba struct {
+		// rand.New(...) returns a non thread safe object
+		r    *rand.Rand
+		lock sync.Mutex
+		// pool holds a *rand.Rand per P if r is nil, so that the goroutines don't contend on lock.
+		pool *sync.Pool
+	}
 
-// NewProba returns a Proba.
+	// A Diffusion is a Decider that is true on exactly the given ratio of calls without randomness.
+	// The fraction left over by each call is carried to the next ones, known as error diffusion,
+	// so that the true results are spread evenly instead of in bursts.
+	Diffusion struct {
+		// acc is the accumulated fraction in math.Float64bits.
+		acc uint64
+	}
+)
+
+var seed = time.Now().UnixNano()
+
+// NewProba returns a Proba, it doesn't take locks.
 func NewProba() *Proba {
 	return &Proba{
-		r: rand.New(rand.NewSource(time.Now().UnixNano())),
+		pool: &sync.Pool{
+			New: func() interface{} {
+				return rand.New(rand.NewSource(atomic.AddInt64(&seed, 1)))
+			},
+		},
+	}
+}
+
+// NewProbaWithSource returns a Proba that generates random numbers from src,
+// a seeded src makes the results reproducible.
+// The calls are serialized with a lock, since src is not safe for concurrent use.
+func NewProbaWithSource(src rand.Source) *Proba {
+	return &Proba{
+		r: rand.New(src),
 	}
 }
 
 // TrueOnProba checks if true on given probability.
 func (p *Proba) TrueOnProba(proba float64) (truth bool) {
+	if p.r == nil {
+		r := p.pool.Get().(*rand.Rand)
+		truth = r.Float64() < proba
+		p.pool.Put(r)
+		return
+	}
+
 	p.lock.Lock()
 	truth = p.r.Float64() < proba
 	p.lock.Unlock()
 	return
 }
+
+// NewDiffusion returns a Diffusion.
+func NewDiffusion() *Diffusion {
+	return new(Diffusion)
+}
+
+// TrueOnProba checks if true on given probability,
+// e.g. it's true on every fourth call with proba 0.25.
+func (d *Diffusion) TrueOnProba(proba float64) (truth bool) {
+	if proba <= 0 {
+		return false
+	}
+
+	for {
+		old := atomic.LoadUint64(&d.acc)
+		acc := math.Float64frombits(old) + proba
+		truth = acc >= 1
+		if truth {
+			acc--
+		}
+		if atomic.CompareAndSwapUint64(&d.acc, old, math.Float64bits(acc)) {
+			return
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package mathx
 
 import (
+	"fmt"
 	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,3 +27,91 @@ func TestTrueOnProba(t *testing.T) {
 	ratio := float64(count) / float64(total)
 	assert.InEpsilon(t, proba, ratio, epsilon)
 }
+
+func TestTrueOnProbaWithSource(t *testing.T) {
+	const proba = 0.5
+	p1 := NewProbaWithSource(rand.NewSource(1))
+	p2 := NewProbaWithSource(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, p1.TrueOnProba(proba), p2.TrueOnProba(proba))
+	}
+}
+
+func TestDiffusion(t *testing.T) {
+	d := NewDiffusion()
+	var results []bool
+	for i := 0; i < 8; i++ {
+		results = append(results, d.TrueOnProba(0.25))
+	}
+	assert.Equal(t, []bool{false, false, false, true, false, false, false, true}, results)
+
+	d = NewDiffusion()
+	var count int
+	for i := 0; i < 1000; i++ {
+		if d.TrueOnProba(math.Pi / 10) {
+			count++
+		}
+	}
+	assert.Equal(t, 314, count)
+
+	d = NewDiffusion()
+	for i := 0; i < 100; i++ {
+		assert.False(t, d.TrueOnProba(0))
+		assert.False(t, d.TrueOnProba(-1))
+		assert.True(t, d.TrueOnProba(1))
+	}
+}
+
+func TestDiffusionConcurrent(t *testing.T) {
+	d := NewDiffusion()
+	var count int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if d.TrueOnProba(0.25) {
+					atomic.AddInt64(&count, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(2000), count)
+}
+
+func BenchmarkProba(b *testing.B) {
+	benchmarkDecider(b, NewProba())
+}
+
+func BenchmarkProbaWithSource(b *testing.B) {
+	benchmarkDecider(b, NewProbaWithSource(rand.NewSource(1)))
+}
+
+func BenchmarkDiffusion(b *testing.B) {
+	benchmarkDecider(b, NewDiffusion())
+}
+
+func benchmarkDecider(b *testing.B, d Decider) {
+	for _, goroutines := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("goroutines-%d", goroutines), func(b *testing.B) {
+			b.ReportAllocs()
+			if goroutines == 1 {
+				for i := 0; i < b.N; i++ {
+					d.TrueOnProba(0.5)
+				}
+				return
+			}
+
+			// RunParallel starts parallelism * GOMAXPROCS goroutines.
+			procs := runtime.GOMAXPROCS(0)
+			b.SetParallelism((goroutines + procs - 1) / procs)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					d.TrueOnProba(0.5)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package timex
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var _ Clock = (*ManualClock)(nil)
+
+type (
+	// A Clock tells the relative time like Now and Since do.
+	Clock interface {
+		// Now returns a relative time duration, the caller only needs to care about the relative value.
+		Now() time.Duration
+		// Since returns a diff since given d.
+		Since(d time.Duration) time.Duration
+	}
+
+	realClock struct{}
+
+	// ManualClock is a Clock that only moves forward when told, it's safe for concurrent use.
+	// It's useful for tests and simulations that need to control the time.
+	ManualClock struct {
+		now int64
+	}
+)
+
+// RealClock returns the Clock that reads the wall clock, which is the same as Now and Since.
+func RealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Duration {
+	return Now()
+}
+
+func (realClock) Since(d time.Duration) time.Duration {
+	return Since(d)
+}
+
+// NewManualClock returns a ManualClock that starts at start.
+func NewManualClock(start time.Duration) *ManualClock {
+	return &ManualClock{now: int64(start)}
+}
+
+// Now returns the current time of the ManualClock.
+func (c *ManualClock) Now() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.now))
+}
+
+// Since returns a diff since given d.
+func (c *ManualClock) Since(d time.Duration) time.Duration {
+	return c.Now() - d
+}
+
+// Advance moves the ManualClock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	atomic.AddInt64(&c.now, int64(d))
+}
+
+// Set moves the ManualClock to now.
+func (c *ManualClock) Set(now time.Duration) {
+	atomic.StoreInt64(&c.now, int64(now))
+}
@@ -0,0 +1,31 @@
+package timex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealClock(t *testing.T) {
+	c := RealClock()
+	now := c.Now()
+	assert.True(t, now > 0)
+	time.Sleep(time.Millisecond)
+	assert.True(t, c.Since(now) > 0)
+	assert.True(t, c.Now() <= Now())
+}
+
+func TestManualClock(t *testing.T) {
+	c := NewManualClock(time.Hour)
+	now := c.Now()
+	assert.Equal(t, time.Hour, now)
+	assert.Equal(t, time.Duration(0), c.Since(now))
+
+	c.Advance(time.Second)
+	assert.Equal(t, time.Hour+time.Second, c.Now())
+	assert.Equal(t, time.Second, c.Since(now))
+
+	c.Set(time.Minute)
+	assert.Equal(t, time.Minute, c.Now())
+}